@@ -0,0 +1,274 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/typed_sync_map"
+)
+
+// Clock allows tests to control the time seen by the cache.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+type EvictionReason string
+
+const (
+	EvictionReasonExpired  EvictionReason = "expired"
+	EvictionReasonCapacity EvictionReason = "capacity"
+	EvictionReasonDeleted  EvictionReason = "deleted"
+)
+
+type Config[K comparable, V any] struct {
+	// TTL is the default time to live of entries. Zero means entries never expire.
+	TTL time.Duration
+	// MaxSize enables LRU eviction once the cache holds more entries. Zero means
+	// no limit.
+	MaxSize int
+	// JanitorInterval is how often expired entries are removed in the background.
+	// Zero disables the janitor, and expired entries are then removed on access.
+	JanitorInterval time.Duration
+	// OnEvict is called after an entry is removed, outside of any lock.
+	OnEvict func(key K, value V, reason EvictionReason)
+	// Clock defaults to the system clock.
+	Clock Clock
+}
+
+type Stats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// Cache is a concurrent cache with per entry expiration and optional LRU
+// eviction. Reads do not take locks unless MaxSize is set.
+type Cache[K comparable, V any] struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	config  Config[K, V]
+	entries typed_sync_map.TypedSyncMap[K, *entry[K, V]]
+
+	mu  sync.Mutex
+	lru *list.List
+
+	stop     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+type entry[K comparable, V any] struct {
+	key       K
+	value     V
+	expiresAt time.Time
+	element   *list.Element
+}
+
+type eviction[K comparable, V any] struct {
+	entry  *entry[K, V]
+	reason EvictionReason
+}
+
+func New[K comparable, V any](config Config[K, V]) *Cache[K, V] {
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	c := &Cache[K, V]{
+		config:  config,
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if config.MaxSize > 0 {
+		c.lru = list.New()
+	}
+	if config.JanitorInterval > 0 {
+		go c.janitor(config.JanitorInterval)
+	} else {
+		close(c.stopped)
+	}
+	return c
+}
+
+func (c *Cache[K, V]) Get(key K) (V, bool) {
+	e, ok := c.entries.Load(key)
+	if ok && c.isExpired(e) {
+		c.removeEntry(e, EvictionReasonExpired)
+		ok = false
+	}
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		var zero V
+		return zero, false
+	}
+
+	if c.lru != nil {
+		c.mu.Lock()
+		if e.element != nil {
+			c.lru.MoveToFront(e.element)
+		}
+		c.mu.Unlock()
+	}
+
+	atomic.AddUint64(&c.hits, 1)
+	return e.value, true
+}
+
+// Set stores the value using the default TTL.
+func (c *Cache[K, V]) Set(key K, value V) {
+	c.SetWithTTL(key, value, c.config.TTL)
+}
+
+// SetWithTTL stores the value with its own time to live. Zero means the entry
+// never expires.
+func (c *Cache[K, V]) SetWithTTL(key K, value V, ttl time.Duration) {
+	e := &entry[K, V]{key: key, value: value}
+	if ttl > 0 {
+		e.expiresAt = c.config.Clock.Now().Add(ttl)
+	}
+
+	if c.lru == nil {
+		c.entries.Store(key, e)
+		return
+	}
+
+	evicted := make([]eviction[K, V], 0)
+	c.mu.Lock()
+	e.element = c.lru.PushFront(e)
+	if previous, loaded := c.entries.Swap(key, e); loaded && previous.element != nil {
+		c.lru.Remove(previous.element)
+		previous.element = nil
+	}
+	for c.lru.Len() > c.config.MaxSize {
+		oldest := c.lru.Back().Value.(*entry[K, V])
+		c.lru.Remove(oldest.element)
+		oldest.element = nil
+		c.entries.CompareAndDelete(oldest.key, oldest)
+		evicted = append(evicted, eviction[K, V]{entry: oldest, reason: EvictionReasonCapacity})
+	}
+	c.mu.Unlock()
+
+	c.notify(evicted...)
+}
+
+func (c *Cache[K, V]) Delete(key K) {
+	e, ok := c.entries.Load(key)
+	if ok {
+		c.removeEntry(e, EvictionReasonDeleted)
+	}
+}
+
+// Clear removes every entry, reporting them to OnEvict as deleted.
+func (c *Cache[K, V]) Clear() {
+	c.entries.Range(func(_ K, e *entry[K, V]) bool {
+		c.removeEntry(e, EvictionReasonDeleted)
+		return true
+	})
+}
+
+// DeleteExpired removes every expired entry. It is called by the janitor.
+func (c *Cache[K, V]) DeleteExpired() {
+	c.entries.Range(func(_ K, e *entry[K, V]) bool {
+		if c.isExpired(e) {
+			c.removeEntry(e, EvictionReasonExpired)
+		}
+		return true
+	})
+}
+
+// Len returns the number of entries, including expired ones that were not
+// removed yet.
+func (c *Cache[K, V]) Len() int {
+	return c.entries.Len()
+}
+
+func (c *Cache[K, V]) Stats() Stats {
+	return Stats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
+
+// Stop stops the janitor and waits for it to exit. The cache remains usable
+// afterwards.
+func (c *Cache[K, V]) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	<-c.stopped
+}
+
+func (c *Cache[K, V]) janitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer close(c.stopped)
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.DeleteExpired()
+		}
+	}
+}
+
+func (c *Cache[K, V]) isExpired(e *entry[K, V]) bool {
+	return !e.expiresAt.IsZero() && !c.config.Clock.Now().Before(e.expiresAt)
+}
+
+// removeEntry removes the entry only if it is still the one stored for its key,
+// so a concurrent Set is never undone.
+func (c *Cache[K, V]) removeEntry(e *entry[K, V], reason EvictionReason) {
+	if c.lru != nil {
+		c.mu.Lock()
+		removed := c.entries.CompareAndDelete(e.key, e)
+		if removed && e.element != nil {
+			c.lru.Remove(e.element)
+			e.element = nil
+		}
+		c.mu.Unlock()
+		if removed {
+			c.notify(eviction[K, V]{entry: e, reason: reason})
+		}
+		return
+	}
+
+	if c.entries.CompareAndDelete(e.key, e) {
+		c.notify(eviction[K, V]{entry: e, reason: reason})
+	}
+}
+
+func (c *Cache[K, V]) notify(evicted ...eviction[K, V]) {
+	for _, ev := range evicted {
+		if ev.reason != EvictionReasonDeleted {
+			atomic.AddUint64(&c.evictions, 1)
+		}
+		if c.config.OnEvict != nil {
+			c.config.OnEvict(ev.entry.key, ev.entry.value, ev.reason)
+		}
+	}
+}
+
+// peek returns the stored value without checking expiration or touching the
+// statistics and the LRU order.
+func (c *Cache[K, V]) peek(key K) (V, bool) {
+	e, ok := c.entries.Load(key)
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	return e.value, true
+}
@@ -0,0 +1,206 @@
+package cache_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type evicted struct {
+	key    string
+	value  int
+	reason cache.EvictionReason
+}
+
+type evictionRecorder struct {
+	mu      sync.Mutex
+	evicted []evicted
+}
+
+func (r *evictionRecorder) OnEvict(key string, value int, reason cache.EvictionReason) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.evicted = append(r.evicted, evicted{key: key, value: value, reason: reason})
+}
+
+func (r *evictionRecorder) Evicted() []evicted {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]evicted{}, r.evicted...)
+}
+
+func TestCache(t *testing.T) {
+	t.Run("Get should return stored values", func(t *testing.T) {
+		c := cache.New(cache.Config[string, int]{})
+
+		c.Set("a", 1)
+		value, ok := c.Get("a")
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+
+		_, ok = c.Get("b")
+		assert.False(t, ok)
+		assert.Equal(t, cache.Stats{Hits: 1, Misses: 1}, c.Stats())
+	})
+
+	t.Run("entries should expire after their TTL", func(t *testing.T) {
+		clock := newFakeClock()
+		recorder := &evictionRecorder{}
+		c := cache.New(cache.Config[string, int]{TTL: time.Minute, Clock: clock, OnEvict: recorder.OnEvict})
+
+		c.Set("a", 1)
+		c.SetWithTTL("b", 2, time.Hour)
+		c.SetWithTTL("c", 3, 0)
+
+		clock.Advance(time.Minute)
+		_, ok := c.Get("a")
+		assert.False(t, ok)
+		_, ok = c.Get("b")
+		assert.True(t, ok)
+
+		clock.Advance(time.Hour)
+		_, ok = c.Get("b")
+		assert.False(t, ok)
+		_, ok = c.Get("c")
+		assert.True(t, ok)
+
+		assert.Equal(t, []evicted{
+			{key: "a", value: 1, reason: cache.EvictionReasonExpired},
+			{key: "b", value: 2, reason: cache.EvictionReasonExpired},
+		}, recorder.Evicted())
+		assert.Equal(t, cache.Stats{Hits: 2, Misses: 2, Evictions: 2}, c.Stats())
+	})
+
+	t.Run("least recently used entries should be evicted when full", func(t *testing.T) {
+		recorder := &evictionRecorder{}
+		c := cache.New(cache.Config[string, int]{MaxSize: 2, OnEvict: recorder.OnEvict})
+
+		c.Set("a", 1)
+		c.Set("b", 2)
+		c.Get("a")
+		c.Set("c", 3)
+
+		_, ok := c.Get("b")
+		assert.False(t, ok)
+		_, ok = c.Get("a")
+		assert.True(t, ok)
+		_, ok = c.Get("c")
+		assert.True(t, ok)
+
+		c.Set("a", 10)
+		c.Set("d", 4)
+		_, ok = c.Get("c")
+		assert.False(t, ok)
+		assert.Equal(t, 2, c.Len())
+
+		assert.Equal(t, []evicted{
+			{key: "b", value: 2, reason: cache.EvictionReasonCapacity},
+			{key: "c", value: 3, reason: cache.EvictionReasonCapacity},
+		}, recorder.Evicted())
+	})
+
+	t.Run("Delete should remove entries", func(t *testing.T) {
+		recorder := &evictionRecorder{}
+		c := cache.New(cache.Config[string, int]{MaxSize: 10, OnEvict: recorder.OnEvict})
+
+		c.Set("a", 1)
+		c.Delete("a")
+		c.Delete("missing")
+
+		_, ok := c.Get("a")
+		assert.False(t, ok)
+		assert.Equal(t, 0, c.Len())
+		assert.Equal(t, []evicted{{key: "a", value: 1, reason: cache.EvictionReasonDeleted}}, recorder.Evicted())
+		assert.Equal(t, uint64(0), c.Stats().Evictions)
+	})
+
+	t.Run("Clear should remove every entry", func(t *testing.T) {
+		recorder := &evictionRecorder{}
+		c := cache.New(cache.Config[string, int]{MaxSize: 10, OnEvict: recorder.OnEvict})
+
+		c.Set("a", 1)
+		c.Set("b", 2)
+		c.Clear()
+
+		assert.Equal(t, 0, c.Len())
+		assert.Len(t, recorder.Evicted(), 2)
+		assert.Equal(t, uint64(0), c.Stats().Evictions)
+	})
+
+	t.Run("DeleteExpired should remove expired entries", func(t *testing.T) {
+		clock := newFakeClock()
+		c := cache.New(cache.Config[string, int]{TTL: time.Minute, MaxSize: 10, Clock: clock})
+
+		c.Set("a", 1)
+		c.SetWithTTL("b", 2, time.Hour)
+		clock.Advance(2 * time.Minute)
+		c.DeleteExpired()
+
+		assert.Equal(t, 1, c.Len())
+	})
+
+	t.Run("janitor should remove expired entries until stopped", func(t *testing.T) {
+		clock := newFakeClock()
+		c := cache.New(cache.Config[string, int]{TTL: time.Minute, JanitorInterval: time.Millisecond, Clock: clock})
+		defer c.Stop()
+
+		c.Set("a", 1)
+		clock.Advance(2 * time.Minute)
+		assert.Eventually(t, func() bool { return c.Len() == 0 }, time.Second, time.Millisecond)
+
+		c.Stop()
+		c.Stop()
+		c.Set("b", 2)
+		clock.Advance(2 * time.Minute)
+		time.Sleep(10 * time.Millisecond)
+		assert.Equal(t, 1, c.Len())
+	})
+
+	t.Run("should be safe for concurrent use", func(t *testing.T) {
+		c := cache.New(cache.Config[string, int]{MaxSize: 50, TTL: time.Minute})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < 1000; j++ {
+					key := strconv.Itoa((i * j) % 100)
+					c.Set(key, j)
+					c.Get(key)
+					if j%10 == 0 {
+						c.Delete(key)
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.LessOrEqual(t, c.Len(), 50)
+	})
+}
@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type LoadingConfig struct {
+	// TTL is how long a loaded value is fresh. Zero means values never expire.
+	TTL time.Duration
+	// StaleTTL is how long a value is still served after its TTL while it is
+	// refreshed in the background. A failed refresh keeps the stale value.
+	StaleTTL time.Duration
+	// RefreshAhead starts a background refresh when a value is read within
+	// RefreshAhead of its TTL, so frequently read keys are reloaded before
+	// they become stale.
+	RefreshAhead time.Duration
+	// ErrorTTL is how long a loader error is cached and returned without
+	// calling the loader again. Zero disables negative caching. Context errors
+	// are never cached.
+	ErrorTTL time.Duration
+	// MaxSize enables LRU eviction once the cache holds more entries. Zero means
+	// no limit.
+	MaxSize int
+	// Clock defaults to the system clock.
+	Clock Clock
+}
+
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// LoadingCache fills itself through loaders and coalesces concurrent loads of
+// the same key, so a burst of misses results in a single call to the loader.
+type LoadingCache[K comparable, V any] struct {
+	config LoadingConfig
+	cache  *Cache[K, *loadResult[V]]
+
+	mu    sync.Mutex
+	calls map[K]*loadCall[V]
+}
+
+type loadResult[V any] struct {
+	value    V
+	err      error
+	loadedAt time.Time
+}
+
+type loadCall[V any] struct {
+	ctx   context.Context
+	done  chan struct{}
+	value V
+	err   error
+}
+
+func NewLoading[K comparable, V any](config LoadingConfig) *LoadingCache[K, V] {
+	if config.Clock == nil {
+		config.Clock = systemClock{}
+	}
+
+	return &LoadingCache[K, V]{
+		config: config,
+		cache: New(Config[K, *loadResult[V]]{
+			MaxSize: config.MaxSize,
+			Clock:   config.Clock,
+		}),
+		calls: make(map[K]*loadCall[V]),
+	}
+}
+
+// GetOrLoad returns the cached value for the key or calls loader to fill it.
+// Concurrent calls for the same key share a single load, which runs with the
+// context of the caller that started it. Every caller stops waiting when its
+// own context is done, and callers whose shared load failed only because the
+// context of another caller was done load the key again.
+func (c *LoadingCache[K, V]) GetOrLoad(ctx context.Context, key K, loader Loader[K, V]) (V, error) {
+	if result, ok := c.cache.Get(key); ok {
+		if result.err != nil {
+			var zero V
+			return zero, result.err
+		}
+		if c.needsRefresh(result) {
+			c.load(context.Background(), key, loader)
+		}
+		return result.value, nil
+	}
+
+	return c.loadAndWait(ctx, key, loader)
+}
+
+// Refresh loads the key again, even if its value is fresh, and waits for the
+// result. When the load fails, the current value is kept.
+func (c *LoadingCache[K, V]) Refresh(ctx context.Context, key K, loader Loader[K, V]) error {
+	_, err := c.loadAndWait(ctx, key, loader)
+	return err
+}
+
+// Delete removes the key. Loads of the key in flight still return their result
+// to their callers, but it is not cached.
+func (c *LoadingCache[K, V]) Delete(key K) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.calls, key)
+	c.cache.Delete(key)
+}
+
+// Clear removes every key, like Delete.
+func (c *LoadingCache[K, V]) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls = make(map[K]*loadCall[V])
+	c.cache.Clear()
+}
+
+func (c *LoadingCache[K, V]) Stats() Stats {
+	return c.cache.Stats()
+}
+
+func (c *LoadingCache[K, V]) needsRefresh(result *loadResult[V]) bool {
+	if c.config.TTL == 0 {
+		return false
+	}
+	refreshAt := result.loadedAt.Add(c.config.TTL - c.config.RefreshAhead)
+	return !c.config.Clock.Now().Before(refreshAt)
+}
+
+func (c *LoadingCache[K, V]) loadAndWait(ctx context.Context, key K, loader Loader[K, V]) (V, error) {
+	for {
+		call := c.load(ctx, key, loader)
+		select {
+		case <-call.done:
+			if call.err != nil && call.ctx.Err() != nil && ctx.Err() == nil {
+				continue
+			}
+			return call.value, call.err
+		case <-ctx.Done():
+			var zero V
+			return zero, ctx.Err()
+		}
+	}
+}
+
+func (c *LoadingCache[K, V]) load(ctx context.Context, key K, loader Loader[K, V]) *loadCall[V] {
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		return call
+	}
+	call := &loadCall[V]{ctx: ctx, done: make(chan struct{})}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	go func() {
+		call.value, call.err = runLoader(ctx, key, loader)
+
+		// The call is no longer registered if the key was deleted while
+		// loading, and its result may be outdated.
+		c.mu.Lock()
+		if c.calls[key] == call {
+			c.store(key, call)
+			delete(c.calls, key)
+		}
+		c.mu.Unlock()
+		close(call.done)
+	}()
+
+	return call
+}
+
+func (c *LoadingCache[K, V]) store(key K, call *loadCall[V]) {
+	if call.err == nil {
+		ttl := time.Duration(0)
+		if c.config.TTL > 0 {
+			ttl = c.config.TTL + c.config.StaleTTL
+		}
+		c.cache.SetWithTTL(key, &loadResult[V]{value: call.value, loadedAt: c.config.Clock.Now()}, ttl)
+		return
+	}
+
+	if c.config.ErrorTTL <= 0 || errors.Is(call.err, context.Canceled) || errors.Is(call.err, context.DeadlineExceeded) {
+		return
+	}
+	if current, ok := c.cache.peek(key); ok && current.err == nil {
+		return
+	}
+	c.cache.SetWithTTL(key, &loadResult[V]{err: call.err, loadedAt: c.config.Clock.Now()}, c.config.ErrorTTL)
+}
+
+func runLoader[K comparable, V any](ctx context.Context, key K, loader Loader[K, V]) (value V, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return loader(ctx, key)
+}
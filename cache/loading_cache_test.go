@@ -0,0 +1,296 @@
+package cache_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/cache"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingLoader struct {
+	calls   int32
+	release chan struct{}
+	value   string
+	err     error
+	mu      sync.Mutex
+}
+
+func (l *countingLoader) Load(ctx context.Context, key string) (string, error) {
+	atomic.AddInt32(&l.calls, 1)
+	if l.release != nil {
+		<-l.release
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return "", l.err
+	}
+	return key + ":" + l.value, nil
+}
+
+func (l *countingLoader) Set(value string, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.value = value
+	l.err = err
+}
+
+func (l *countingLoader) Calls() int {
+	return int(atomic.LoadInt32(&l.calls))
+}
+
+func TestLoadingCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("GetOrLoad should cache loaded values", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{value: "v1"}
+
+		value, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v1", value)
+
+		value, err = c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v1", value)
+		assert.Equal(t, 1, loader.Calls())
+	})
+
+	t.Run("concurrent loads of the same key should be coalesced", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{value: "v1", release: make(chan struct{})}
+
+		var wg sync.WaitGroup
+		results := make([]string, 200)
+		for i := range results {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				results[i], _ = c.GetOrLoad(ctx, "tenant", loader.Load)
+			}(i)
+		}
+		time.Sleep(20 * time.Millisecond)
+		close(loader.release)
+		wg.Wait()
+
+		assert.Equal(t, 1, loader.Calls())
+		for _, result := range results {
+			assert.Equal(t, "tenant:v1", result)
+		}
+	})
+
+	t.Run("errors should not be cached by default", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{err: errors.New("vault is down")}
+
+		_, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.EqualError(t, err, "vault is down")
+
+		loader.Set("v1", nil)
+		value, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v1", value)
+		assert.Equal(t, 2, loader.Calls())
+	})
+
+	t.Run("errors should be cached for ErrorTTL", func(t *testing.T) {
+		clock := newFakeClock()
+		c := cache.NewLoading[string, string](cache.LoadingConfig{ErrorTTL: time.Second, Clock: clock})
+		loader := &countingLoader{err: errors.New("vault is down")}
+
+		_, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.Error(t, err)
+		_, err = c.GetOrLoad(ctx, "a", loader.Load)
+		assert.Error(t, err)
+		assert.Equal(t, 1, loader.Calls())
+
+		loader.Set("v1", nil)
+		clock.Advance(time.Second)
+		value, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v1", value)
+		assert.Equal(t, 2, loader.Calls())
+	})
+
+	t.Run("panics should be returned as errors", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+
+		_, err := c.GetOrLoad(ctx, "a", func(ctx context.Context, key string) (string, error) {
+			panic("boom")
+		})
+		assert.EqualError(t, err, "panic: boom")
+	})
+
+	t.Run("stale values should be served while refreshing", func(t *testing.T) {
+		clock := newFakeClock()
+		c := cache.NewLoading[string, string](cache.LoadingConfig{TTL: time.Minute, StaleTTL: time.Minute, Clock: clock})
+		loader := &countingLoader{value: "v1"}
+
+		_, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+
+		loader.Set("v2", nil)
+		clock.Advance(90 * time.Second)
+		value, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v1", value)
+
+		assert.Eventually(t, func() bool {
+			value, _ := c.GetOrLoad(ctx, "a", loader.Load)
+			return value == "a:v2"
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 2, loader.Calls())
+	})
+
+	t.Run("failed refreshes should keep the stale value", func(t *testing.T) {
+		clock := newFakeClock()
+		c := cache.NewLoading[string, string](cache.LoadingConfig{TTL: time.Minute, StaleTTL: time.Minute, ErrorTTL: time.Minute, Clock: clock})
+		loader := &countingLoader{value: "v1"}
+
+		_, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+
+		loader.Set("", errors.New("vault is down"))
+		err = c.Refresh(ctx, "a", loader.Load)
+		assert.Error(t, err)
+
+		clock.Advance(90 * time.Second)
+		value, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v1", value)
+
+		clock.Advance(time.Minute)
+		_, err = c.GetOrLoad(ctx, "a", loader.Load)
+		assert.EqualError(t, err, "vault is down")
+	})
+
+	t.Run("Refresh should replace fresh values", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{value: "v1"}
+
+		_, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+
+		loader.Set("v2", nil)
+		assert.NoError(t, c.Refresh(ctx, "a", loader.Load))
+
+		value, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v2", value)
+	})
+
+	t.Run("waiters should stop when their context is done", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{value: "v1", release: make(chan struct{})}
+		defer close(loader.release)
+
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+
+		_, err := c.GetOrLoad(timeoutCtx, "a", loader.Load)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("Delete should force a new load", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{value: "v1"}
+
+		c.GetOrLoad(ctx, "a", loader.Load)
+		c.Delete("a")
+		c.GetOrLoad(ctx, "a", loader.Load)
+
+		assert.Equal(t, 2, loader.Calls())
+	})
+
+	t.Run("values should be refreshed ahead of their TTL", func(t *testing.T) {
+		clock := newFakeClock()
+		c := cache.NewLoading[string, string](cache.LoadingConfig{TTL: time.Minute, RefreshAhead: 10 * time.Second, Clock: clock})
+		loader := &countingLoader{value: "v1"}
+
+		c.GetOrLoad(ctx, "a", loader.Load)
+		clock.Advance(45 * time.Second)
+		c.GetOrLoad(ctx, "a", loader.Load)
+		assert.Equal(t, 1, loader.Calls())
+
+		loader.Set("v2", nil)
+		clock.Advance(10 * time.Second)
+		value, err := c.GetOrLoad(ctx, "a", loader.Load)
+		assert.NoError(t, err)
+		assert.Equal(t, "a:v1", value)
+
+		assert.Eventually(t, func() bool {
+			value, _ := c.GetOrLoad(ctx, "a", loader.Load)
+			return value == "a:v2"
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, 2, loader.Calls())
+	})
+
+	t.Run("loads in flight should not be cached after Delete", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{value: "v1", release: make(chan struct{})}
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			value, err := c.GetOrLoad(ctx, "a", loader.Load)
+			assert.NoError(t, err)
+			assert.Equal(t, "a:v1", value)
+		}()
+
+		assert.Eventually(t, func() bool { return loader.Calls() == 1 }, time.Second, time.Millisecond)
+		c.Delete("a")
+		close(loader.release)
+		<-done
+
+		c.GetOrLoad(ctx, "a", loader.Load)
+		assert.Equal(t, 2, loader.Calls())
+	})
+
+	t.Run("Clear should force new loads of every key", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		loader := &countingLoader{value: "v1"}
+
+		c.GetOrLoad(ctx, "a", loader.Load)
+		c.GetOrLoad(ctx, "b", loader.Load)
+		c.Clear()
+		c.GetOrLoad(ctx, "a", loader.Load)
+		c.GetOrLoad(ctx, "b", loader.Load)
+
+		assert.Equal(t, 4, loader.Calls())
+	})
+
+	t.Run("waiters should not fail because another caller's context is done", func(t *testing.T) {
+		c := cache.NewLoading[string, string](cache.LoadingConfig{})
+		release := make(chan struct{})
+		var calls int32
+		loader := func(ctx context.Context, key string) (string, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				<-release
+				return "", ctx.Err()
+			}
+			return "value", nil
+		}
+
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		go c.GetOrLoad(cancelledCtx, "a", loader)
+		assert.Eventually(t, func() bool { return atomic.LoadInt32(&calls) == 1 }, time.Second, time.Millisecond)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			value, err := c.GetOrLoad(ctx, "a", loader)
+			assert.NoError(t, err)
+			assert.Equal(t, "value", value)
+		}()
+
+		cancel()
+		close(release)
+		<-done
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+}
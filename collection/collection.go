@@ -0,0 +1,114 @@
+// Package collection provides generic helpers over slices. Functions never
+// modify their input, and slices they return keep the order of the input
+// unless stated otherwise.
+package collection
+
+import "golang.org/x/exp/constraints"
+
+func Map[T any, R any](a []T, mapper func(T) R) []R {
+	result := make([]R, len(a))
+	for i, e := range a {
+		result[i] = mapper(e)
+	}
+	return result
+}
+
+func Filter[T any](a []T, predicate func(T) bool) []T {
+	result := make([]T, 0)
+	for _, e := range a {
+		if predicate(e) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Reduce folds the slice from left to right, starting with initial.
+func Reduce[T any, R any](a []T, initial R, reducer func(R, T) R) R {
+	result := initial
+	for _, e := range a {
+		result = reducer(result, e)
+	}
+	return result
+}
+
+// GroupBy groups elements by key. Elements inside each group keep the order of
+// the input.
+func GroupBy[T any, K comparable](a []T, key func(T) K) map[K][]T {
+	result := make(map[K][]T)
+	for _, e := range a {
+		k := key(e)
+		result[k] = append(result[k], e)
+	}
+	return result
+}
+
+// KeyBy indexes elements by key. When several elements share a key, the last
+// one wins.
+func KeyBy[T any, K comparable](a []T, key func(T) K) map[K]T {
+	result := make(map[K]T, len(a))
+	for _, e := range a {
+		result[key(e)] = e
+	}
+	return result
+}
+
+// Partition splits the slice into the elements that satisfy the predicate and
+// the ones that do not.
+func Partition[T any](a []T, predicate func(T) bool) ([]T, []T) {
+	matched := make([]T, 0)
+	unmatched := make([]T, 0)
+	for _, e := range a {
+		if predicate(e) {
+			matched = append(matched, e)
+		} else {
+			unmatched = append(unmatched, e)
+		}
+	}
+	return matched, unmatched
+}
+
+// Chunk splits the slice into chunks of the given size. The last chunk holds the
+// remaining elements and may be smaller. Chunk panics if size is not positive.
+func Chunk[T any](a []T, size int) [][]T {
+	if size <= 0 {
+		panic("collection: chunk size must be positive")
+	}
+
+	result := make([][]T, 0, (len(a)+size-1)/size)
+	for start := 0; start < len(a); start += size {
+		end := start + size
+		if end > len(a) {
+			end = len(a)
+		}
+		chunk := make([]T, end-start)
+		copy(chunk, a[start:end])
+		result = append(result, chunk)
+	}
+	return result
+}
+
+func Flatten[T any](a [][]T) []T {
+	size := 0
+	for _, s := range a {
+		size += len(s)
+	}
+
+	result := make([]T, 0, size)
+	for _, s := range a {
+		result = append(result, s...)
+	}
+	return result
+}
+
+type Number interface {
+	constraints.Integer | constraints.Float
+}
+
+func SumBy[T any, N Number](a []T, value func(T) N) N {
+	var sum N
+	for _, e := range a {
+		sum += value(e)
+	}
+	return sum
+}
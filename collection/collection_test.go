@@ -0,0 +1,148 @@
+package collection_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/criticalmassbr/ms-utils/collection"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type employee struct {
+	ID         int
+	Department string
+	Active     bool
+}
+
+var employees = []employee{
+	{ID: 1, Department: "sales", Active: true},
+	{ID: 2, Department: "support", Active: false},
+	{ID: 3, Department: "sales", Active: false},
+	{ID: 4, Department: "engineering", Active: true},
+}
+
+func TestCollection(t *testing.T) {
+	t.Run("Map should transform every element", func(t *testing.T) {
+		got := collection.Map(employees, func(e employee) string { return strconv.Itoa(e.ID) })
+		assert.Equal(t, []string{"1", "2", "3", "4"}, got)
+		assert.Equal(t, []string{}, collection.Map([]int{}, strconv.Itoa))
+		assert.Equal(t, []string{}, collection.Map(nil, strconv.Itoa))
+	})
+
+	t.Run("Filter should keep matching elements", func(t *testing.T) {
+		got := collection.Filter(employees, func(e employee) bool { return e.Active })
+		assert.Equal(t, []employee{employees[0], employees[3]}, got)
+		assert.Equal(t, []int{}, collection.Filter([]int{1, 2}, func(int) bool { return false }))
+		assert.Equal(t, []int{}, collection.Filter(nil, func(int) bool { return true }))
+	})
+
+	t.Run("Reduce should fold from left to right", func(t *testing.T) {
+		got := collection.Reduce([]string{"a", "b", "c"}, ">", func(acc string, e string) string { return acc + e })
+		assert.Equal(t, ">abc", got)
+		assert.Equal(t, ">", collection.Reduce(nil, ">", func(acc string, e string) string { return acc + e }))
+	})
+
+	t.Run("GroupBy should keep input order inside groups", func(t *testing.T) {
+		got := collection.GroupBy(employees, func(e employee) string { return e.Department })
+		assert.Equal(t, map[string][]employee{
+			"sales":       {employees[0], employees[2]},
+			"support":     {employees[1]},
+			"engineering": {employees[3]},
+		}, got)
+		assert.Equal(t, map[string][]employee{}, collection.GroupBy(nil, func(e employee) string { return e.Department }))
+	})
+
+	t.Run("KeyBy should keep the last element of each key", func(t *testing.T) {
+		got := collection.KeyBy(employees, func(e employee) string { return e.Department })
+		assert.Equal(t, map[string]employee{
+			"sales":       employees[2],
+			"support":     employees[1],
+			"engineering": employees[3],
+		}, got)
+		assert.Equal(t, map[string]employee{}, collection.KeyBy(nil, func(e employee) string { return e.Department }))
+	})
+
+	t.Run("Partition should split matching and unmatched elements", func(t *testing.T) {
+		active, inactive := collection.Partition(employees, func(e employee) bool { return e.Active })
+		assert.Equal(t, []employee{employees[0], employees[3]}, active)
+		assert.Equal(t, []employee{employees[1], employees[2]}, inactive)
+
+		active, inactive = collection.Partition(nil, func(e employee) bool { return e.Active })
+		assert.Equal(t, []employee{}, active)
+		assert.Equal(t, []employee{}, inactive)
+	})
+
+	t.Run("Chunk should split in chunks of the given size", func(t *testing.T) {
+		assert.Equal(t, [][]int{{1, 2}, {3, 4}, {5}}, collection.Chunk([]int{1, 2, 3, 4, 5}, 2))
+		assert.Equal(t, [][]int{{1, 2}}, collection.Chunk([]int{1, 2}, 5))
+		assert.Equal(t, [][]int{}, collection.Chunk([]int{}, 5))
+		assert.Equal(t, [][]int{}, collection.Chunk[int](nil, 5))
+		assert.Panics(t, func() { collection.Chunk([]int{1}, 0) })
+	})
+
+	t.Run("Chunk should not share memory with the input", func(t *testing.T) {
+		input := []int{1, 2, 3}
+		chunks := collection.Chunk(input, 2)
+		chunks[0][0] = 10
+		assert.Equal(t, []int{1, 2, 3}, input)
+	})
+
+	t.Run("Flatten should concatenate slices in order", func(t *testing.T) {
+		assert.Equal(t, []int{1, 2, 3, 4}, collection.Flatten([][]int{{1, 2}, {}, {3}, {4}}))
+		assert.Equal(t, []int{1}, collection.Flatten([][]int{nil, {1}, nil}))
+		assert.Equal(t, []int{}, collection.Flatten[int](nil))
+		assert.Equal(t, []int{1, 2, 3}, collection.Flatten(collection.Chunk([]int{1, 2, 3}, 2)))
+	})
+
+	t.Run("SumBy should add the values", func(t *testing.T) {
+		assert.Equal(t, 10, collection.SumBy(employees, func(e employee) int { return e.ID }))
+		assert.Equal(t, 0.0, collection.SumBy([]employee{}, func(e employee) float64 { return 1 }))
+		assert.Equal(t, 0, collection.SumBy(nil, func(e employee) int { return e.ID }))
+	})
+}
+
+func BenchmarkCollection(b *testing.B) {
+	ids := make([]uuid.UUID, 100000)
+	for i := range ids {
+		ids[i] = uuid.New()
+	}
+
+	b.Run("Map", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			collection.Map(ids, func(id uuid.UUID) string { return id.String() })
+		}
+	})
+
+	b.Run("Filter", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			collection.Filter(ids, func(id uuid.UUID) bool { return id[0] < 128 })
+		}
+	})
+
+	b.Run("GroupBy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			collection.GroupBy(ids, func(id uuid.UUID) byte { return id[0] })
+		}
+	})
+
+	b.Run("KeyBy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			collection.KeyBy(ids, func(id uuid.UUID) uuid.UUID { return id })
+		}
+	})
+
+	b.Run("Chunk", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			collection.Chunk(ids, 1000)
+		}
+	})
+
+	b.Run("Flatten", func(b *testing.B) {
+		chunks := collection.Chunk(ids, 1000)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			collection.Flatten(chunks)
+		}
+	})
+}
@@ -34,6 +34,10 @@ type HealthCheckRedisConfig struct {
 type HealthCheckVaultConfig struct {
 	Url  string
 	Cert string
+	// AuthCheck is called once Vault reports it is healthy, so the check also
+	// fails when the service cannot authenticate, e.g. with the HealthCheck
+	// method of the vault package AuthStatus.
+	AuthCheck func() error
 }
 
 type HealthCheckConfig struct {
@@ -200,5 +204,11 @@ func vaultHealthCheck(config *HealthCheckVaultConfig) error {
 		return fmt.Errorf("vault is not healthy: %v", resp)
 	}
 
+	if config.AuthCheck != nil {
+		if err := config.AuthCheck(); err != nil {
+			return fmt.Errorf("vault auth is not healthy: %w", err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,69 @@
+package utils_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/criticalmassbr/ms-utils/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultHealthCheck(t *testing.T) {
+	t.Run("should pass while Vault is unsealed", func(t *testing.T) {
+		server := vaulttest.NewServer(t)
+
+		err := utils.VaultHealthCheck(&utils.HealthCheckVaultConfig{Url: server.URL, Cert: server.CertFile})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, server.Count("sys/health"))
+	})
+
+	t.Run("should fail while Vault is sealed or down", func(t *testing.T) {
+		server := vaulttest.NewServer(t)
+		config := &utils.HealthCheckVaultConfig{Url: server.URL, Cert: server.CertFile}
+
+		server.SetSealed(true)
+		assert.ErrorContains(t, utils.VaultHealthCheck(config), "vault is not healthy")
+
+		server.SetSealed(false)
+		server.SetDown(true)
+		assert.Error(t, utils.VaultHealthCheck(config))
+	})
+
+	t.Run("should fail when the certificate is not trusted", func(t *testing.T) {
+		server := vaulttest.NewServer(t)
+		certFile := filepath.Join(t.TempDir(), "other.pem")
+		assert.NoError(t, os.WriteFile(certFile, []byte{}, 0o600))
+
+		err := utils.VaultHealthCheck(&utils.HealthCheckVaultConfig{Url: server.URL, Cert: certFile})
+		assert.ErrorContains(t, err, "certificate")
+
+		err = utils.VaultHealthCheck(&utils.HealthCheckVaultConfig{Url: server.URL, Cert: filepath.Join(t.TempDir(), "missing.pem")})
+		assert.ErrorContains(t, err, "unable to read Vault certificate")
+	})
+
+	t.Run("should fail when the auth check fails", func(t *testing.T) {
+		server := vaulttest.NewServer(t)
+		repo, err := vault.NewVaultRepository(server.Config())
+		assert.NoError(t, err)
+		defer repo.Close()
+
+		config := &utils.HealthCheckVaultConfig{
+			Url:  server.URL,
+			Cert: server.CertFile,
+			AuthCheck: func() error {
+				return repo.AuthStatus().HealthCheck()
+			},
+		}
+		assert.NoError(t, utils.VaultHealthCheck(config))
+
+		repo.Close()
+		assert.ErrorContains(t, utils.VaultHealthCheck(config), "vault auth is not healthy")
+
+		config.AuthCheck = func() error { return errors.New("token expired") }
+		assert.ErrorContains(t, utils.VaultHealthCheck(config), "token expired")
+	})
+}
@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type ParallelOption func(*parallelOptions)
+
+type parallelOptions struct {
+	collectAllErrors bool
+	tracerName       string
+	spanName         string
+}
+
+// WithCollectAllErrors runs every item even if some of them fail and returns
+// all errors joined in item order. By default the first error cancels the
+// context passed to the remaining items and no new items are started.
+func WithCollectAllErrors() ParallelOption {
+	return func(o *parallelOptions) {
+		o.collectAllErrors = true
+	}
+}
+
+// WithItemSpan opens a child span of the context for every item, tagged with
+// the item index and failed when the item returns an error.
+func WithItemSpan(tracerName string, spanName string) ParallelOption {
+	return func(o *parallelOptions) {
+		o.tracerName = tracerName
+		o.spanName = spanName
+	}
+}
+
+// ParallelMap calls fn for every item with at most limit calls running at the
+// same time. A limit lower than one means no limit. Results are returned in
+// the same order as items, and results of items that failed or were never
+// started because of an error or a cancelled context are left as zero values.
+func ParallelMap[T any, R any](ctx context.Context, items []T, limit int, fn func(ctx context.Context, item T) (R, error), opts ...ParallelOption) ([]R, error) {
+	options := parallelOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	if limit < 1 || limit > len(items) {
+		limit = len(items)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]R, len(items))
+	errs := make([]error, len(items))
+	semaphore := make(chan struct{}, limit)
+
+	var (
+		wg        sync.WaitGroup
+		firstErr  error
+		errOnce   sync.Once
+		scheduled int
+	)
+
+schedule:
+	for i := range items {
+		select {
+		case <-runCtx.Done():
+			break schedule
+		case semaphore <- struct{}{}:
+		}
+
+		if runCtx.Err() != nil {
+			<-semaphore
+			break schedule
+		}
+
+		scheduled++
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result, err := runParallelItem(runCtx, i, items[i], fn, options)
+			if err != nil {
+				errs[i] = err
+				if !options.collectAllErrors {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+				return
+			}
+			results[i] = result
+		}(i)
+	}
+
+	wg.Wait()
+
+	if !options.collectAllErrors {
+		if firstErr != nil {
+			return results, firstErr
+		}
+		if scheduled < len(items) {
+			return results, ctx.Err()
+		}
+		return results, nil
+	}
+
+	joined := make([]error, 0)
+	for i, err := range errs {
+		if err != nil {
+			joined = append(joined, fmt.Errorf("item %d: %w", i, err))
+		}
+	}
+	if scheduled < len(items) {
+		joined = append(joined, ctx.Err())
+	}
+	return results, errors.Join(joined...)
+}
+
+// ForEachLimit is ParallelMap for functions that only return an error.
+func ForEachLimit[T any](ctx context.Context, items []T, limit int, fn func(ctx context.Context, item T) error, opts ...ParallelOption) error {
+	_, err := ParallelMap(ctx, items, limit, func(ctx context.Context, item T) (struct{}, error) {
+		return struct{}{}, fn(ctx, item)
+	}, opts...)
+	return err
+}
+
+func runParallelItem[T any, R any](ctx context.Context, index int, item T, fn func(ctx context.Context, item T) (R, error), options parallelOptions) (result R, err error) {
+	if options.spanName != "" {
+		var span trace.Span
+		ctx, span = Tracer.NewSpan(ctx, options.tracerName, options.spanName)
+		Tracer.AddSpanTags(span, map[string]string{"parallel.index": strconv.Itoa(index)})
+		defer func() {
+			if err != nil {
+				Tracer.AddSpanErrorAndFail(span, err, "parallel item failed")
+			}
+			span.End()
+		}()
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return fn(ctx, item)
+}
@@ -0,0 +1,166 @@
+package utils_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestParallelMap(t *testing.T) {
+	ctx := context.Background()
+	items := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+	t.Run("should return results in item order", func(t *testing.T) {
+		got, err := utils.ParallelMap(ctx, items, 3, func(ctx context.Context, item int) (int, error) {
+			time.Sleep(time.Duration(10-item) * time.Millisecond)
+			return item * 2, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{2, 4, 6, 8, 10, 12, 14, 16, 18, 20}, got)
+	})
+
+	t.Run("should respect the concurrency limit", func(t *testing.T) {
+		var running, maxRunning int32
+		_, err := utils.ParallelMap(ctx, items, 3, func(ctx context.Context, item int) (int, error) {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				max := atomic.LoadInt32(&maxRunning)
+				if n <= max || atomic.CompareAndSwapInt32(&maxRunning, max, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return item, nil
+		})
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, maxRunning, int32(3))
+		assert.Greater(t, maxRunning, int32(1))
+	})
+
+	t.Run("should stop on the first error", func(t *testing.T) {
+		itemErr := errors.New("failed")
+		var calls int32
+
+		_, err := utils.ParallelMap(ctx, items, 1, func(ctx context.Context, item int) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			if item == 3 {
+				return 0, itemErr
+			}
+			return item, nil
+		})
+		assert.ErrorIs(t, err, itemErr)
+		assert.Equal(t, int32(3), calls)
+	})
+
+	t.Run("should cancel running items on the first error", func(t *testing.T) {
+		itemErr := errors.New("failed")
+
+		err := utils.ForEachLimit(ctx, []int{1, 2}, 2, func(ctx context.Context, item int) error {
+			if item == 1 {
+				return itemErr
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		})
+		assert.ErrorIs(t, err, itemErr)
+	})
+
+	t.Run("should collect all errors", func(t *testing.T) {
+		firstErr := errors.New("first")
+		secondErr := errors.New("second")
+		var calls int32
+
+		got, err := utils.ParallelMap(ctx, items, 2, func(ctx context.Context, item int) (int, error) {
+			atomic.AddInt32(&calls, 1)
+			switch item {
+			case 2:
+				return 0, firstErr
+			case 7:
+				return 0, secondErr
+			}
+			return item, nil
+		}, utils.WithCollectAllErrors())
+		assert.ErrorIs(t, err, firstErr)
+		assert.ErrorIs(t, err, secondErr)
+		assert.Equal(t, "item 1: first\nitem 6: second", err.Error())
+		assert.Equal(t, int32(10), calls)
+		assert.Equal(t, []int{1, 0, 3, 4, 5, 6, 0, 8, 9, 10}, got)
+	})
+
+	t.Run("should recover panics as errors", func(t *testing.T) {
+		err := utils.ForEachLimit(ctx, items, 0, func(ctx context.Context, item int) error {
+			if item == 5 {
+				panic("boom")
+			}
+			return nil
+		})
+		assert.EqualError(t, err, "panic: boom")
+	})
+
+	t.Run("should not start items after the context is cancelled", func(t *testing.T) {
+		cancelledCtx, cancel := context.WithCancel(ctx)
+		cancel()
+		var calls int32
+
+		err := utils.ForEachLimit(cancelledCtx, items, 2, func(ctx context.Context, item int) error {
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, int32(0), calls)
+	})
+
+	t.Run("should handle empty slices", func(t *testing.T) {
+		got, err := utils.ParallelMap(ctx, []int{}, 3, func(ctx context.Context, item int) (int, error) {
+			return item, nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []int{}, got)
+	})
+
+	t.Run("should open a span per item", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+		defer otel.SetTracerProvider(previous)
+
+		parentCtx, parent := utils.Tracer.NewSpan(ctx, "test", "parent")
+		err := utils.ForEachLimit(parentCtx, []int{1, 2, 3}, 2, func(ctx context.Context, item int) error {
+			if item == 2 {
+				return errors.New("failed")
+			}
+			return nil
+		}, utils.WithItemSpan("test", "item"), utils.WithCollectAllErrors())
+		parent.End()
+		assert.Error(t, err)
+
+		spans := recorder.Ended()
+		assert.Len(t, spans, 4)
+
+		failed := 0
+		for _, span := range spans {
+			if span.Name() != "item" {
+				continue
+			}
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+			if span.Status().Code == codes.Error {
+				failed++
+			}
+		}
+		assert.Equal(t, 1, failed)
+	})
+}
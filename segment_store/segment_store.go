@@ -0,0 +1,413 @@
+package segmentstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/criticalmassbr/ms-utils/collection"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Member is the type used to identify an employee inside a segment.
+type Member interface{ int | uuid.UUID }
+
+type StoreConfig struct {
+	// TTL defaults to one hour if not set
+	TTL time.Duration `koanf:"ttl"`
+	// KeyPrefix defaults to "segment" if not set
+	KeyPrefix string `koanf:"key_prefix"`
+}
+
+// EmployeeChange describes an employee that was created, updated or deleted
+// since the segments of its client were materialized.
+type EmployeeChange[M Member] struct {
+	EmployeeID M
+	Deleted    bool
+}
+
+// Matcher reports whether the employee currently matches the filter conditions.
+// Exclusions are handled by the store and do not need to be checked.
+type Matcher[T utils.Excludable, M Member] func(ctx context.Context, clientSlug string, filter utils.Filter[T], employeeID M) (bool, error)
+
+// Store materializes the result of a filter into a Redis set keyed by the
+// client slug and the filter fingerprint. Every key of a client shares the
+// same hash tag, so set operations between segments also work on a cluster.
+type Store[T utils.Excludable, M Member] struct {
+	client redis.UniversalClient
+	ttl    time.Duration
+	prefix string
+}
+
+var (
+	ErrSegmentNotMaterialized = errors.New("segment not materialized")
+)
+
+const (
+	defaultTTL       = time.Hour
+	defaultKeyPrefix = "segment"
+	addChunkSize     = 10000
+)
+
+func NewV1(client redis.UniversalClient, cfg StoreConfig) *Store[utils.ExcludableV1, int] {
+	return newStore[utils.ExcludableV1, int](client, cfg)
+}
+
+func NewBurst(client redis.UniversalClient, cfg StoreConfig) *Store[utils.ExcludableBurst, uuid.UUID] {
+	return newStore[utils.ExcludableBurst, uuid.UUID](client, cfg)
+}
+
+func newStore[T utils.Excludable, M Member](client redis.UniversalClient, cfg StoreConfig) *Store[T, M] {
+	store := &Store[T, M]{
+		client: client,
+		ttl:    cfg.TTL,
+		prefix: cfg.KeyPrefix,
+	}
+	if store.ttl <= 0 {
+		store.ttl = defaultTTL
+	}
+	if store.prefix == "" {
+		store.prefix = defaultKeyPrefix
+	}
+	return store
+}
+
+// Materialize replaces the segment of the filter with the given members, minus
+// the users excluded by the filter, and resets its expiration.
+func (s *Store[T, M]) Materialize(ctx context.Context, clientSlug string, filter utils.Filter[T], members []M) error {
+	fingerprint, err := filter.Fingerprint()
+	if err != nil {
+		return err
+	}
+
+	encodedFilter, err := json.Marshal(filter)
+	if err != nil {
+		return err
+	}
+
+	excluded := excludedMembers[T, M](filter)
+	values := make([]interface{}, 0, len(members))
+	for _, member := range members {
+		if _, ok := excluded[member]; ok {
+			continue
+		}
+		values = append(values, encodeMember(member))
+	}
+
+	setKey := s.setKey(clientSlug, fingerprint)
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, setKey)
+		for start := 0; start < len(values); start += addChunkSize {
+			end := start + addChunkSize
+			if end > len(values) {
+				end = len(values)
+			}
+			pipe.SAdd(ctx, setKey, values[start:end]...)
+		}
+		pipe.Expire(ctx, setKey, s.ttl)
+		pipe.Set(ctx, s.filterKey(clientSlug, fingerprint), encodedFilter, s.ttl)
+		pipe.SAdd(ctx, s.indexKey(clientSlug), fingerprint)
+		return nil
+	})
+	return err
+}
+
+// Get returns the members of a materialized segment. The boolean is false if
+// the segment was never materialized or has expired.
+func (s *Store[T, M]) Get(ctx context.Context, clientSlug string, filter utils.Filter[T]) ([]M, bool, error) {
+	fingerprint, err := filter.Fingerprint()
+	if err != nil {
+		return nil, false, err
+	}
+
+	materialized, err := s.isMaterialized(ctx, clientSlug, fingerprint)
+	if err != nil || !materialized {
+		return nil, false, err
+	}
+
+	values, err := s.client.SMembers(ctx, s.setKey(clientSlug, fingerprint)).Result()
+	if err != nil {
+		return nil, false, err
+	}
+
+	members, err := decodeMembers[M](values)
+	if err != nil {
+		return nil, false, err
+	}
+	return members, true, nil
+}
+
+// GetOrMaterialize returns the members of the segment, calling load and
+// materializing its result when the segment is missing or expired.
+func (s *Store[T, M]) GetOrMaterialize(ctx context.Context, clientSlug string, filter utils.Filter[T], load func(ctx context.Context) ([]M, error)) ([]M, error) {
+	members, ok, err := s.Get(ctx, clientSlug, filter)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return members, nil
+	}
+
+	members, err = load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Materialize(ctx, clientSlug, filter, members); err != nil {
+		return nil, err
+	}
+
+	excluded := excludedMembers[T, M](filter)
+	return collection.Filter(members, func(member M) bool {
+		_, ok := excluded[member]
+		return !ok
+	}), nil
+}
+
+// Refresh applies employee changes to every materialized segment of the client
+// without recomputing them. Expiration is not extended, so segments are still
+// fully recomputed once their TTL is reached. Expired segments found along the
+// way are removed from the client index.
+func (s *Store[T, M]) Refresh(ctx context.Context, clientSlug string, changes []EmployeeChange[M], match Matcher[T, M]) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	fingerprints, err := s.client.SMembers(ctx, s.indexKey(clientSlug)).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, fingerprint := range fingerprints {
+		encodedFilter, err := s.client.Get(ctx, s.filterKey(clientSlug, fingerprint)).Bytes()
+		if errors.Is(err, redis.Nil) {
+			if err := s.removeSegment(ctx, clientSlug, fingerprint); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var filter utils.Filter[T]
+		if err := json.Unmarshal(encodedFilter, &filter); err != nil {
+			return fmt.Errorf("unable to decode filter of segment %s: %w", fingerprint, err)
+		}
+
+		added, removed, err := s.classifyChanges(ctx, clientSlug, filter, changes, match)
+		if err != nil {
+			return err
+		}
+
+		setKey := s.setKey(clientSlug, fingerprint)
+		_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			if len(added) > 0 {
+				pipe.SAdd(ctx, setKey, added...)
+			}
+			if len(removed) > 0 {
+				pipe.SRem(ctx, setKey, removed...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if err := s.alignSetExpiration(ctx, clientSlug, fingerprint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Union returns the members that belong to at least one of the segments.
+func (s *Store[T, M]) Union(ctx context.Context, clientSlug string, filters ...utils.Filter[T]) ([]M, error) {
+	keys, err := s.materializedSetKeys(ctx, clientSlug, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.client.SUnion(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeMembers[M](values)
+}
+
+// Intersect returns the members that belong to every one of the segments.
+func (s *Store[T, M]) Intersect(ctx context.Context, clientSlug string, filters ...utils.Filter[T]) ([]M, error) {
+	keys, err := s.materializedSetKeys(ctx, clientSlug, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := s.client.SInter(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+	return decodeMembers[M](values)
+}
+
+// Invalidate removes the segment of the filter, forcing it to be recomputed.
+func (s *Store[T, M]) Invalidate(ctx context.Context, clientSlug string, filter utils.Filter[T]) error {
+	fingerprint, err := filter.Fingerprint()
+	if err != nil {
+		return err
+	}
+	return s.removeSegment(ctx, clientSlug, fingerprint)
+}
+
+func (s *Store[T, M]) classifyChanges(ctx context.Context, clientSlug string, filter utils.Filter[T], changes []EmployeeChange[M], match Matcher[T, M]) ([]interface{}, []interface{}, error) {
+	excluded := excludedMembers[T, M](filter)
+	added := make([]interface{}, 0)
+	removed := make([]interface{}, 0)
+
+	for _, change := range changes {
+		if _, ok := excluded[change.EmployeeID]; change.Deleted || ok {
+			removed = append(removed, encodeMember(change.EmployeeID))
+			continue
+		}
+
+		matches, err := match(ctx, clientSlug, filter, change.EmployeeID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if matches {
+			added = append(added, encodeMember(change.EmployeeID))
+		} else {
+			removed = append(removed, encodeMember(change.EmployeeID))
+		}
+	}
+
+	return added, removed, nil
+}
+
+// alignSetExpiration makes sure a set that was empty when the segment was
+// materialized, and therefore had no key to expire, does not outlive it.
+func (s *Store[T, M]) alignSetExpiration(ctx context.Context, clientSlug string, fingerprint string) error {
+	setKey := s.setKey(clientSlug, fingerprint)
+
+	setTTL, err := s.client.PTTL(ctx, setKey).Result()
+	if err != nil || setTTL != -1 {
+		return err
+	}
+
+	filterTTL, err := s.client.PTTL(ctx, s.filterKey(clientSlug, fingerprint)).Result()
+	if err != nil {
+		return err
+	}
+	if filterTTL <= 0 {
+		return s.client.Del(ctx, setKey).Err()
+	}
+	return s.client.PExpire(ctx, setKey, filterTTL).Err()
+}
+
+func (s *Store[T, M]) materializedSetKeys(ctx context.Context, clientSlug string, filters []utils.Filter[T]) ([]string, error) {
+	if len(filters) == 0 {
+		return nil, errors.New("at least one filter is required")
+	}
+
+	keys := make([]string, len(filters))
+	for i, filter := range filters {
+		fingerprint, err := filter.Fingerprint()
+		if err != nil {
+			return nil, err
+		}
+
+		materialized, err := s.isMaterialized(ctx, clientSlug, fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		if !materialized {
+			return nil, fmt.Errorf("%w: %s", ErrSegmentNotMaterialized, fingerprint)
+		}
+
+		keys[i] = s.setKey(clientSlug, fingerprint)
+	}
+	return keys, nil
+}
+
+func (s *Store[T, M]) isMaterialized(ctx context.Context, clientSlug string, fingerprint string) (bool, error) {
+	n, err := s.client.Exists(ctx, s.filterKey(clientSlug, fingerprint)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (s *Store[T, M]) removeSegment(ctx context.Context, clientSlug string, fingerprint string) error {
+	_, err := s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.setKey(clientSlug, fingerprint), s.filterKey(clientSlug, fingerprint))
+		pipe.SRem(ctx, s.indexKey(clientSlug), fingerprint)
+		return nil
+	})
+	return err
+}
+
+func (s *Store[T, M]) setKey(clientSlug string, fingerprint string) string {
+	return fmt.Sprintf("%s:{%s}:%s:members", s.prefix, clientSlug, fingerprint)
+}
+
+func (s *Store[T, M]) filterKey(clientSlug string, fingerprint string) string {
+	return fmt.Sprintf("%s:{%s}:%s:filter", s.prefix, clientSlug, fingerprint)
+}
+
+func (s *Store[T, M]) indexKey(clientSlug string) string {
+	return fmt.Sprintf("%s:{%s}:index", s.prefix, clientSlug)
+}
+
+func excludedMembers[T utils.Excludable, M Member](filter utils.Filter[T]) map[M]struct{} {
+	var users []M
+	switch e := any(filter.Exclude).(type) {
+	case utils.ExcludableV1:
+		users, _ = any(e.Users).([]M)
+	case utils.ExcludableBurst:
+		users, _ = any(e.Users).([]M)
+	}
+
+	excluded := make(map[M]struct{}, len(users))
+	for _, user := range users {
+		excluded[user] = struct{}{}
+	}
+	return excluded
+}
+
+func encodeMember[M Member](member M) string {
+	switch m := any(member).(type) {
+	case int:
+		return strconv.Itoa(m)
+	case uuid.UUID:
+		return m.String()
+	}
+	return ""
+}
+
+func decodeMembers[M Member](values []string) ([]M, error) {
+	members := make([]M, len(values))
+	for i, value := range values {
+		var decoded interface{}
+		switch any(members[i]).(type) {
+		case int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment member %q: %w", value, err)
+			}
+			decoded = n
+		case uuid.UUID:
+			id, err := uuid.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment member %q: %w", value, err)
+			}
+			decoded = id
+		}
+		members[i] = decoded.(M)
+	}
+	return members, nil
+}
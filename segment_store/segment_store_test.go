@@ -0,0 +1,237 @@
+package segmentstore_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	utils "github.com/criticalmassbr/ms-utils"
+	segmentstore "github.com/criticalmassbr/ms-utils/segment_store"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStore(t *testing.T) (*segmentstore.Store[utils.ExcludableV1, int], *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return segmentstore.NewV1(client, segmentstore.StoreConfig{TTL: time.Minute}), server
+}
+
+func departmentFilter(departments []int, excluded []int) utils.Filter[utils.ExcludableV1] {
+	return utils.Filter[utils.ExcludableV1]{
+		Relation: utils.RelationAnd,
+		Conditions: []utils.Condition{
+			{FieldName: utils.FieldNameDepartmentId, Operator: utils.OperatorIn, Value: departments},
+		},
+		Exclude: utils.ExcludableV1{Users: excluded},
+	}
+}
+
+func sorted(members []int) []int {
+	sort.Ints(members)
+	return members
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Get should report segments that were never materialized", func(t *testing.T) {
+		store, _ := newTestStore(t)
+
+		members, ok, err := store.Get(ctx, "client1", departmentFilter([]int{1}, nil))
+		assert.NoError(t, err)
+		assert.False(t, ok)
+		assert.Nil(t, members)
+	})
+
+	t.Run("Materialize should store members without excluded users", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		filter := departmentFilter([]int{1, 2}, []int{3})
+
+		err := store.Materialize(ctx, "client1", filter, []int{1, 2, 3, 4})
+		assert.NoError(t, err)
+
+		members, ok, err := store.Get(ctx, "client1", filter)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []int{1, 2, 4}, sorted(members))
+
+		_, ok, err = store.Get(ctx, "client2", filter)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("equivalent filters should share the same segment", func(t *testing.T) {
+		store, _ := newTestStore(t)
+
+		err := store.Materialize(ctx, "client1", departmentFilter([]int{1, 2}, []int{5, 3}), []int{1, 2})
+		assert.NoError(t, err)
+
+		members, ok, err := store.Get(ctx, "client1", departmentFilter([]int{2, 1}, []int{3, 5}))
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []int{1, 2}, sorted(members))
+	})
+
+	t.Run("empty segments should be materialized", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		filter := departmentFilter([]int{1}, nil)
+
+		assert.NoError(t, store.Materialize(ctx, "client1", filter, []int{}))
+
+		members, ok, err := store.Get(ctx, "client1", filter)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Empty(t, members)
+	})
+
+	t.Run("GetOrMaterialize should load only missing segments", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		filter := departmentFilter([]int{1}, []int{2})
+		calls := 0
+		load := func(ctx context.Context) ([]int, error) {
+			calls++
+			return []int{1, 2, 3}, nil
+		}
+
+		members, err := store.GetOrMaterialize(ctx, "client1", filter, load)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 3}, members)
+
+		members, err = store.GetOrMaterialize(ctx, "client1", filter, load)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 3}, sorted(members))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("GetOrMaterialize should return loader errors", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		loadErr := errors.New("db is down")
+
+		_, err := store.GetOrMaterialize(ctx, "client1", departmentFilter([]int{1}, nil), func(ctx context.Context) ([]int, error) {
+			return nil, loadErr
+		})
+		assert.ErrorIs(t, err, loadErr)
+	})
+
+	t.Run("segments should expire", func(t *testing.T) {
+		store, server := newTestStore(t)
+		filter := departmentFilter([]int{1}, nil)
+
+		assert.NoError(t, store.Materialize(ctx, "client1", filter, []int{1}))
+		server.FastForward(2 * time.Minute)
+
+		_, ok, err := store.Get(ctx, "client1", filter)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Refresh should apply employee changes to every segment of the client", func(t *testing.T) {
+		store, server := newTestStore(t)
+		sales := departmentFilter([]int{1}, nil)
+		support := departmentFilter([]int{2}, []int{30})
+		departments := map[int]int{10: 1, 11: 1, 20: 2, 30: 2}
+
+		assert.NoError(t, store.Materialize(ctx, "client1", sales, []int{10, 11}))
+		assert.NoError(t, store.Materialize(ctx, "client1", support, []int{20, 30}))
+
+		departments[11] = 2
+		departments[12] = 1
+		changes := []segmentstore.EmployeeChange[int]{
+			{EmployeeID: 11},
+			{EmployeeID: 12},
+			{EmployeeID: 20, Deleted: true},
+			{EmployeeID: 30},
+		}
+		err := store.Refresh(ctx, "client1", changes, func(ctx context.Context, clientSlug string, filter utils.Filter[utils.ExcludableV1], employeeID int) (bool, error) {
+			return utils.Contains(filter.Conditions[0].Value.([]int), departments[employeeID]), nil
+		})
+		assert.NoError(t, err)
+
+		members, _, err := store.Get(ctx, "client1", sales)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{10, 12}, sorted(members))
+
+		members, _, err = store.Get(ctx, "client1", support)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{11}, sorted(members))
+
+		server.FastForward(2 * time.Minute)
+		_, ok, err := store.Get(ctx, "client1", support)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Refresh should return matcher errors", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		matchErr := errors.New("db is down")
+
+		assert.NoError(t, store.Materialize(ctx, "client1", departmentFilter([]int{1}, nil), []int{1}))
+
+		err := store.Refresh(ctx, "client1", []segmentstore.EmployeeChange[int]{{EmployeeID: 2}}, func(ctx context.Context, clientSlug string, filter utils.Filter[utils.ExcludableV1], employeeID int) (bool, error) {
+			return false, matchErr
+		})
+		assert.ErrorIs(t, err, matchErr)
+	})
+
+	t.Run("Union and Intersect should combine segments", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		first := departmentFilter([]int{1}, nil)
+		second := departmentFilter([]int{2}, nil)
+
+		assert.NoError(t, store.Materialize(ctx, "client1", first, []int{1, 2, 3}))
+		assert.NoError(t, store.Materialize(ctx, "client1", second, []int{3, 4}))
+
+		members, err := store.Union(ctx, "client1", first, second)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4}, sorted(members))
+
+		members, err = store.Intersect(ctx, "client1", first, second)
+		assert.NoError(t, err)
+		assert.Equal(t, []int{3}, members)
+
+		_, err = store.Union(ctx, "client1", first, departmentFilter([]int{3}, nil))
+		assert.ErrorIs(t, err, segmentstore.ErrSegmentNotMaterialized)
+	})
+
+	t.Run("Invalidate should remove the segment", func(t *testing.T) {
+		store, _ := newTestStore(t)
+		filter := departmentFilter([]int{1}, nil)
+
+		assert.NoError(t, store.Materialize(ctx, "client1", filter, []int{1}))
+		assert.NoError(t, store.Invalidate(ctx, "client1", filter))
+
+		_, ok, err := store.Get(ctx, "client1", filter)
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+
+	t.Run("Burst segments should store uuids", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		defer client.Close()
+		store := segmentstore.NewBurst(client, segmentstore.StoreConfig{})
+
+		first, second := uuid.New(), uuid.New()
+		filter := utils.Filter[utils.ExcludableBurst]{
+			Relation: utils.RelationOr,
+			Conditions: []utils.Condition{
+				{FieldName: utils.FieldNameEmail, Operator: utils.OperatorEq, Value: "someone@example.com"},
+			},
+			Exclude: utils.ExcludableBurst{Users: []uuid.UUID{second}},
+		}
+
+		assert.NoError(t, store.Materialize(ctx, "client1", filter, []uuid.UUID{first, second}))
+
+		members, ok, err := store.Get(ctx, "client1", filter)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, []uuid.UUID{first}, members)
+	})
+}
@@ -1,8 +1,11 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -33,7 +36,7 @@ type FieldFilter[T Excludable] struct {
 type Condition struct {
 	FieldName FieldName   `json:"fieldName"`
 	Operator  Operator    `json:"operator"`
-	Value     interface{} `json:"value"` // RFCDate | [2]RFCDate | int | []int | string | []string | uuid.UUID | []uuid.UUID
+	Value     interface{} `json:"value"` // RFCDate | [2]RFCDate | int | []int | string | []string | uuid.UUID | []uuid.UUID | GeoRadius | GeoPolygon
 }
 
 func (c *Condition) UnmarshalJSON(data []byte) error {
@@ -46,6 +49,14 @@ func (c *Condition) UnmarshalJSON(data []byte) error {
 	if err := json.Unmarshal(data, aux); err != nil {
 		return err
 	}
+	if isLocationOperator(c.Operator) {
+		v, err := castToLocationValue(c.Operator, c.Value)
+		if err != nil {
+			return err
+		}
+		c.Value = v
+		return nil
+	}
 	switch v := c.Value.(type) {
 	case []interface{}:
 		if len(v) == 0 {
@@ -246,6 +257,8 @@ const (
 	FieldNameRelationalCustom7 FieldName = "custom7"
 	FieldNameRelationalCustom8 FieldName = "custom8"
 	FieldNameRelationalCustom9 FieldName = "custom9"
+
+	FieldNameLocation FieldName = "location"
 )
 
 type FieldCount string
@@ -302,6 +315,9 @@ const (
 	OperatorBetween Operator = "between"
 	OperatorGt      Operator = "gt"
 	OperatorLt      Operator = "lt"
+
+	OperatorWithinRadius  Operator = "withinRadius"
+	OperatorWithinPolygon Operator = "withinPolygon"
 )
 
 type ValidateCondition struct {
@@ -356,6 +372,19 @@ var (
 				},
 			},
 		},
+		{
+			Fields: []FieldName{FieldNameLocation},
+			ValidOperators: []ValidateOperator{
+				{
+					Operators:           []Operator{OperatorWithinRadius},
+					ValueTypeValidators: []func(interface{}) bool{isGeoRadius},
+				},
+				{
+					Operators:           []Operator{OperatorWithinPolygon},
+					ValueTypeValidators: []func(interface{}) bool{isGeoPolygon},
+				},
+			},
+		},
 	}
 	ValidConditionsBurst = []ValidateCondition{
 		{
@@ -398,6 +427,19 @@ var (
 				},
 			},
 		},
+		{
+			Fields: []FieldName{FieldNameLocation},
+			ValidOperators: []ValidateOperator{
+				{
+					Operators:           []Operator{OperatorWithinRadius},
+					ValueTypeValidators: []func(interface{}) bool{isGeoRadius},
+				},
+				{
+					Operators:           []Operator{OperatorWithinPolygon},
+					ValueTypeValidators: []func(interface{}) bool{isGeoPolygon},
+				},
+			},
+		},
 	}
 
 	ValidCountFields = []FieldCount{
@@ -427,6 +469,66 @@ func (filter *Filter[T]) Validate(validConditions []ValidateCondition) bool {
 	return isEveryFilterConditionValid(filter, validConditions)
 }
 
+// Fingerprint returns a stable hash of the filter. Filters that differ only in
+// the order of their conditions, condition values or excluded users share the
+// same fingerprint. The filter itself is not modified.
+func (filter *Filter[T]) Fingerprint() (string, error) {
+	conditions := make([]Condition, len(filter.Conditions))
+	for i, condition := range filter.Conditions {
+		conditions[i] = Condition{
+			FieldName: condition.FieldName,
+			Operator:  condition.Operator,
+			Value:     normalizeConditionValue(condition.Value),
+		}
+	}
+
+	encodedValues := make([]string, len(conditions))
+	for i, condition := range conditions {
+		encoded, err := json.Marshal(condition)
+		if err != nil {
+			return "", err
+		}
+		encodedValues[i] = string(encoded)
+	}
+	sort.Strings(encodedValues)
+
+	var exclude interface{}
+	switch e := any(filter.Exclude).(type) {
+	case ExcludableV1:
+		exclude = SortAndRemoveDuplicates(append([]int{}, e.Users...))
+	case ExcludableBurst:
+		exclude = SortAndRemoveDuplicateUUIDs(append([]uuid.UUID{}, e.Users...))
+	}
+
+	data, err := json.Marshal(struct {
+		Relation   Relation    `json:"relation"`
+		Conditions []string    `json:"conditions"`
+		Exclude    interface{} `json:"exclude"`
+	}{
+		Relation:   filter.Relation,
+		Conditions: encodedValues,
+		Exclude:    exclude,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func normalizeConditionValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []int:
+		return SortAndRemoveDuplicates(append([]int{}, v...))
+	case []string:
+		return SortAndRemoveDuplicates(append([]string{}, v...))
+	case []uuid.UUID:
+		return SortAndRemoveDuplicateUUIDs(append([]uuid.UUID{}, v...))
+	}
+	return value
+}
+
 func isEveryFilterConditionValid[T Excludable](filter *Filter[T], validConditions []ValidateCondition) bool {
 	for _, condition := range filter.Conditions {
 		if !isConditionValid(condition, validConditions) {
@@ -547,3 +649,13 @@ func isUUIDSlice(t interface{}) bool {
 	_, ok := t.([]uuid.UUID)
 	return ok
 }
+
+func isGeoRadius(t interface{}) bool {
+	radius, ok := t.(GeoRadius)
+	return ok && radius.IsValid()
+}
+
+func isGeoPolygon(t interface{}) bool {
+	polygon, ok := t.(GeoPolygon)
+	return ok && polygon.IsValid()
+}
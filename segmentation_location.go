@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+)
+
+const earthRadiusKm = 6371.0088
+
+type GeoPoint struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+}
+
+type GeoRadius struct {
+	Lat float64 `json:"lat"`
+	Lon float64 `json:"lon"`
+	Km  float64 `json:"km"`
+}
+
+// GeoPolygon is a list of vertices. The polygon is closed implicitly, so the
+// first vertex does not need to be repeated at the end.
+type GeoPolygon []GeoPoint
+
+type LocationSQLDialect string
+
+const (
+	// LocationSQLDialectEarthDistance requires the cube and earthdistance
+	// extensions. Polygons use the built-in geometric types.
+	LocationSQLDialectEarthDistance LocationSQLDialect = "earthdistance"
+	LocationSQLDialectPostGIS       LocationSQLDialect = "postgis"
+)
+
+// LocationColumnMapping describes where the employee location is stored.
+// Column names are written as-is into the generated SQL and must never come
+// from user input.
+type LocationColumnMapping struct {
+	Dialect   LocationSQLDialect
+	Latitude  string
+	Longitude string
+	// Geography is an optional PostGIS geography column. When empty, the point
+	// is built from Latitude and Longitude.
+	Geography string
+}
+
+var (
+	ErrNotLocationCondition = errors.New("condition is not a location condition")
+)
+
+func (p GeoPoint) IsValid() bool {
+	return p.Lat >= -90 && p.Lat <= 90 && p.Lon >= -180 && p.Lon <= 180
+}
+
+func (r GeoRadius) Center() GeoPoint {
+	return GeoPoint{Lat: r.Lat, Lon: r.Lon}
+}
+
+func (r GeoRadius) IsValid() bool {
+	return r.Center().IsValid() && r.Km > 0
+}
+
+func (r GeoRadius) Contains(point GeoPoint) bool {
+	return HaversineDistance(r.Center(), point) <= r.Km
+}
+
+func (p GeoPolygon) IsValid() bool {
+	if len(p) < 3 {
+		return false
+	}
+	for _, point := range p {
+		if !point.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains uses ray casting over latitude and longitude, which is accurate
+// enough for city-sized polygons that do not cross the antimeridian.
+func (p GeoPolygon) Contains(point GeoPoint) bool {
+	inside := false
+	for i, j := 0, len(p)-1; i < len(p); j, i = i, i+1 {
+		a, b := p[i], p[j]
+		if (a.Lat > point.Lat) != (b.Lat > point.Lat) &&
+			point.Lon < (b.Lon-a.Lon)*(point.Lat-a.Lat)/(b.Lat-a.Lat)+a.Lon {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// HaversineDistance returns the great-circle distance between two points in km.
+func HaversineDistance(a GeoPoint, b GeoPoint) float64 {
+	lat1, lat2 := toRadians(a.Lat), toRadians(b.Lat)
+	dLat := lat2 - lat1
+	dLon := toRadians(b.Lon - a.Lon)
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+// MatchesLocation evaluates a location condition against an employee location.
+func (c *Condition) MatchesLocation(point GeoPoint) (bool, error) {
+	switch c.Operator {
+	case OperatorWithinRadius:
+		radius, ok := c.Value.(GeoRadius)
+		if !ok {
+			return false, errors.New("value is invalid")
+		}
+		return radius.Contains(point), nil
+	case OperatorWithinPolygon:
+		polygon, ok := c.Value.(GeoPolygon)
+		if !ok {
+			return false, errors.New("value is invalid")
+		}
+		return polygon.Contains(point), nil
+	}
+	return false, ErrNotLocationCondition
+}
+
+// LocationSQL returns a Postgres boolean expression for a location condition
+// and its arguments. Placeholders are numbered starting at firstPlaceholder.
+func (c *Condition) LocationSQL(mapping LocationColumnMapping, firstPlaceholder int) (string, []interface{}, error) {
+	if !isLocationOperator(c.Operator) {
+		return "", nil, ErrNotLocationCondition
+	}
+
+	switch mapping.Dialect {
+	case LocationSQLDialectEarthDistance:
+		if mapping.Latitude == "" || mapping.Longitude == "" {
+			return "", nil, errors.New("latitude and longitude columns are required")
+		}
+		return c.earthDistanceSQL(mapping, firstPlaceholder)
+	case LocationSQLDialectPostGIS:
+		if mapping.Geography == "" && (mapping.Latitude == "" || mapping.Longitude == "") {
+			return "", nil, errors.New("geography or latitude and longitude columns are required")
+		}
+		return c.postGISSQL(mapping, firstPlaceholder)
+	}
+	return "", nil, fmt.Errorf("unsupported location sql dialect %q", mapping.Dialect)
+}
+
+func (c *Condition) earthDistanceSQL(mapping LocationColumnMapping, n int) (string, []interface{}, error) {
+	switch v := c.Value.(type) {
+	case GeoRadius:
+		center := fmt.Sprintf("ll_to_earth($%d, $%d)", n, n+1)
+		location := fmt.Sprintf("ll_to_earth(%s, %s)", mapping.Latitude, mapping.Longitude)
+		query := fmt.Sprintf("(earth_box(%s, $%d) @> %s AND earth_distance(%s, %s) <= $%d)", center, n+2, location, center, location, n+2)
+		return query, []interface{}{v.Lat, v.Lon, v.Km * 1000}, nil
+	case GeoPolygon:
+		query := fmt.Sprintf("($%d::polygon @> point(%s, %s))", n, mapping.Longitude, mapping.Latitude)
+		return query, []interface{}{v.pointsText()}, nil
+	}
+	return "", nil, errors.New("value is invalid")
+}
+
+func (c *Condition) postGISSQL(mapping LocationColumnMapping, n int) (string, []interface{}, error) {
+	location := mapping.Geography
+	if location == "" {
+		location = fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s, %s), 4326)::geography", mapping.Longitude, mapping.Latitude)
+	}
+
+	switch v := c.Value.(type) {
+	case GeoRadius:
+		query := fmt.Sprintf("ST_DWithin(%s, ST_SetSRID(ST_MakePoint($%d, $%d), 4326)::geography, $%d)", location, n, n+1, n+2)
+		return query, []interface{}{v.Lon, v.Lat, v.Km * 1000}, nil
+	case GeoPolygon:
+		query := fmt.Sprintf("ST_Covers(ST_GeogFromText($%d), %s)", n, location)
+		return query, []interface{}{v.wkt()}, nil
+	}
+	return "", nil, errors.New("value is invalid")
+}
+
+func (p GeoPolygon) pointsText() string {
+	points := make([]string, len(p))
+	for i, point := range p {
+		points[i] = fmt.Sprintf("(%v,%v)", point.Lon, point.Lat)
+	}
+	return "(" + strings.Join(points, ",") + ")"
+}
+
+func (p GeoPolygon) wkt() string {
+	ring := append(GeoPolygon{}, p...)
+	if len(ring) > 0 && ring[0] != ring[len(ring)-1] {
+		ring = append(ring, ring[0])
+	}
+
+	points := make([]string, len(ring))
+	for i, point := range ring {
+		points[i] = fmt.Sprintf("%v %v", point.Lon, point.Lat)
+	}
+	return "SRID=4326;POLYGON((" + strings.Join(points, ", ") + "))"
+}
+
+func isLocationOperator(operator Operator) bool {
+	return operator == OperatorWithinRadius || operator == OperatorWithinPolygon
+}
+
+func castToLocationValue(operator Operator, v interface{}) (interface{}, error) {
+	switch operator {
+	case OperatorWithinRadius:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("value is invalid")
+		}
+		return castToGeoRadius(m)
+	case OperatorWithinPolygon:
+		points, ok := v.([]interface{})
+		if !ok || len(points) == 0 {
+			return nil, errors.New("value is required")
+		}
+		return castToGeoPolygon(points)
+	}
+	return nil, ErrNotLocationCondition
+}
+
+func castToGeoRadius(v map[string]interface{}) (GeoRadius, error) {
+	point, err := castToGeoPoint(v)
+	if err != nil {
+		return GeoRadius{}, err
+	}
+	km, ok := v["km"].(float64)
+	if !ok {
+		return GeoRadius{}, errors.New("km is required")
+	}
+	return GeoRadius{Lat: point.Lat, Lon: point.Lon, Km: km}, nil
+}
+
+func castToGeoPolygon(v []interface{}) (GeoPolygon, error) {
+	polygon := make(GeoPolygon, 0, len(v))
+	for _, vv := range v {
+		m, ok := vv.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("value is invalid")
+		}
+		point, err := castToGeoPoint(m)
+		if err != nil {
+			return nil, err
+		}
+		polygon = append(polygon, point)
+	}
+	return polygon, nil
+}
+
+func castToGeoPoint(v map[string]interface{}) (GeoPoint, error) {
+	lat, ok := v["lat"].(float64)
+	if !ok {
+		return GeoPoint{}, errors.New("lat is required")
+	}
+	lon, ok := v["lon"].(float64)
+	if !ok {
+		return GeoPoint{}, errors.New("lon is required")
+	}
+	return GeoPoint{Lat: lat, Lon: lon}, nil
+}
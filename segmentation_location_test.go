@@ -0,0 +1,183 @@
+package utils_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	saoPaulo      = utils.GeoPoint{Lat: -23.5505, Lon: -46.6333}
+	rioDeJaneiro  = utils.GeoPoint{Lat: -22.9068, Lon: -43.1729}
+	guarulhos     = utils.GeoPoint{Lat: -23.4538, Lon: -46.5333}
+	saoPauloBlock = utils.GeoPolygon{
+		{Lat: -23.60, Lon: -46.70},
+		{Lat: -23.60, Lon: -46.55},
+		{Lat: -23.50, Lon: -46.55},
+		{Lat: -23.50, Lon: -46.70},
+	}
+)
+
+func TestLocationConditionUnmarshal(t *testing.T) {
+	t.Run("should parse withinRadius values", func(t *testing.T) {
+		var condition utils.Condition
+		err := json.Unmarshal([]byte(`{"fieldName":"location","operator":"withinRadius","value":{"lat":-23.5505,"lon":-46.6333,"km":50}}`), &condition)
+		assert.NoError(t, err)
+		assert.Equal(t, utils.GeoRadius{Lat: -23.5505, Lon: -46.6333, Km: 50}, condition.Value)
+	})
+
+	t.Run("should parse withinPolygon values", func(t *testing.T) {
+		var condition utils.Condition
+		err := json.Unmarshal([]byte(`{"fieldName":"location","operator":"withinPolygon","value":[{"lat":1,"lon":2},{"lat":3,"lon":4},{"lat":5,"lon":6}]}`), &condition)
+		assert.NoError(t, err)
+		assert.Equal(t, utils.GeoPolygon{{Lat: 1, Lon: 2}, {Lat: 3, Lon: 4}, {Lat: 5, Lon: 6}}, condition.Value)
+	})
+
+	t.Run("should return error when coordinates are missing", func(t *testing.T) {
+		var condition utils.Condition
+		err := json.Unmarshal([]byte(`{"fieldName":"location","operator":"withinRadius","value":{"lat":-23.5505,"km":50}}`), &condition)
+		assert.Error(t, err)
+
+		err = json.Unmarshal([]byte(`{"fieldName":"location","operator":"withinPolygon","value":[]}`), &condition)
+		assert.Error(t, err)
+	})
+}
+
+func TestLocationConditionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition utils.Condition
+		want      bool
+	}{
+		{
+			name:      "valid radius",
+			condition: utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: -23.5, Lon: -46.6, Km: 50}},
+			want:      true,
+		},
+		{
+			name:      "radius must be positive",
+			condition: utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: -23.5, Lon: -46.6}},
+			want:      false,
+		},
+		{
+			name:      "latitude must be in range",
+			condition: utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: -123.5, Lon: -46.6, Km: 50}},
+			want:      false,
+		},
+		{
+			name:      "valid polygon",
+			condition: utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinPolygon, Value: saoPauloBlock},
+			want:      true,
+		},
+		{
+			name:      "polygon needs at least three points",
+			condition: utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinPolygon, Value: saoPauloBlock[:2]},
+			want:      false,
+		},
+		{
+			name:      "operator must match value kind",
+			condition: utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinPolygon, Value: utils.GeoRadius{Lat: -23.5, Lon: -46.6, Km: 50}},
+			want:      false,
+		},
+		{
+			name:      "location operators are not valid for other fields",
+			condition: utils.Condition{FieldName: utils.FieldNameCity, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: -23.5, Lon: -46.6, Km: 50}},
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v1 := utils.Filter[utils.ExcludableV1]{Conditions: []utils.Condition{tt.condition}}
+			burst := utils.Filter[utils.ExcludableBurst]{Conditions: []utils.Condition{tt.condition}}
+			assert.Equal(t, tt.want, v1.Validate(utils.ValidConditionsV1))
+			assert.Equal(t, tt.want, burst.Validate(utils.ValidConditionsBurst))
+		})
+	}
+}
+
+func TestMatchesLocation(t *testing.T) {
+	t.Run("HaversineDistance should return the distance in km", func(t *testing.T) {
+		assert.InDelta(t, 361, utils.HaversineDistance(saoPaulo, rioDeJaneiro), 2)
+		assert.Equal(t, 0.0, utils.HaversineDistance(saoPaulo, saoPaulo))
+	})
+
+	t.Run("withinRadius should match points inside the radius", func(t *testing.T) {
+		condition := utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: saoPaulo.Lat, Lon: saoPaulo.Lon, Km: 50}}
+
+		matches, err := condition.MatchesLocation(guarulhos)
+		assert.NoError(t, err)
+		assert.True(t, matches)
+
+		matches, err = condition.MatchesLocation(rioDeJaneiro)
+		assert.NoError(t, err)
+		assert.False(t, matches)
+	})
+
+	t.Run("withinPolygon should match points inside the polygon", func(t *testing.T) {
+		condition := utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinPolygon, Value: saoPauloBlock}
+
+		matches, err := condition.MatchesLocation(saoPaulo)
+		assert.NoError(t, err)
+		assert.True(t, matches)
+
+		matches, err = condition.MatchesLocation(guarulhos)
+		assert.NoError(t, err)
+		assert.False(t, matches)
+	})
+
+	t.Run("should return error for other conditions", func(t *testing.T) {
+		condition := utils.Condition{FieldName: utils.FieldNameCity, Operator: utils.OperatorEq, Value: 1}
+
+		_, err := condition.MatchesLocation(saoPaulo)
+		assert.ErrorIs(t, err, utils.ErrNotLocationCondition)
+	})
+}
+
+func TestLocationSQL(t *testing.T) {
+	radius := utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: -23.5, Lon: -46.6, Km: 50}}
+	polygon := utils.Condition{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinPolygon, Value: utils.GeoPolygon{{Lat: 1, Lon: 2}, {Lat: 3, Lon: 4}, {Lat: 5, Lon: 6}}}
+	earthDistance := utils.LocationColumnMapping{Dialect: utils.LocationSQLDialectEarthDistance, Latitude: "e.lat", Longitude: "e.lon"}
+
+	t.Run("earthdistance radius", func(t *testing.T) {
+		query, args, err := radius.LocationSQL(earthDistance, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, "(earth_box(ll_to_earth($3, $4), $5) @> ll_to_earth(e.lat, e.lon) AND earth_distance(ll_to_earth($3, $4), ll_to_earth(e.lat, e.lon)) <= $5)", query)
+		assert.Equal(t, []interface{}{-23.5, -46.6, 50000.0}, args)
+	})
+
+	t.Run("earthdistance polygon", func(t *testing.T) {
+		query, args, err := polygon.LocationSQL(earthDistance, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "($1::polygon @> point(e.lon, e.lat))", query)
+		assert.Equal(t, []interface{}{"((2,1),(4,3),(6,5))"}, args)
+	})
+
+	t.Run("postgis radius with geography column", func(t *testing.T) {
+		query, args, err := radius.LocationSQL(utils.LocationColumnMapping{Dialect: utils.LocationSQLDialectPostGIS, Geography: "e.geog"}, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, "ST_DWithin(e.geog, ST_SetSRID(ST_MakePoint($1, $2), 4326)::geography, $3)", query)
+		assert.Equal(t, []interface{}{-46.6, -23.5, 50000.0}, args)
+	})
+
+	t.Run("postgis polygon with coordinate columns", func(t *testing.T) {
+		query, args, err := polygon.LocationSQL(utils.LocationColumnMapping{Dialect: utils.LocationSQLDialectPostGIS, Latitude: "lat", Longitude: "lon"}, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, "ST_Covers(ST_GeogFromText($2), ST_SetSRID(ST_MakePoint(lon, lat), 4326)::geography)", query)
+		assert.Equal(t, []interface{}{"SRID=4326;POLYGON((2 1, 4 3, 6 5, 2 1))"}, args)
+	})
+
+	t.Run("should return error for invalid mappings and conditions", func(t *testing.T) {
+		_, _, err := radius.LocationSQL(utils.LocationColumnMapping{Dialect: utils.LocationSQLDialectEarthDistance}, 1)
+		assert.Error(t, err)
+
+		_, _, err = radius.LocationSQL(utils.LocationColumnMapping{Latitude: "lat", Longitude: "lon"}, 1)
+		assert.Error(t, err)
+
+		other := utils.Condition{FieldName: utils.FieldNameCity, Operator: utils.OperatorEq, Value: 1}
+		_, _, err = other.LocationSQL(earthDistance, 1)
+		assert.ErrorIs(t, err, utils.ErrNotLocationCondition)
+	})
+}
@@ -0,0 +1,348 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slices"
+)
+
+// ConditionMergeLoss describes duplicate conditions that could not be combined
+// into a single equivalent condition. Kept is the condition left in the result
+// and Dropped holds the conditions whose values were discarded or approximated.
+type ConditionMergeLoss struct {
+	FieldName FieldName
+	Operator  Operator
+	Kept      Condition
+	Dropped   []Condition
+	Reason    string
+}
+
+type operatorFamily string
+
+const (
+	operatorFamilyMembership operatorFamily = "membership"
+	operatorFamilyExclusion  operatorFamily = "exclusion"
+)
+
+// MergeDuplicateConditions is the lossless alternative to
+// SortAndRemoveDuplicateConditions. Conditions on the same field are combined
+// according to the filter relation:
+//
+//   - eq/in: union under "or", intersection under "and"
+//   - ne/notIn: intersection under "or", union under "and"
+//   - gt/lt: tightest bound under "and", loosest bound under "or"
+//   - between: intersection under "and", union of overlapping ranges under "or"
+//
+// Merged membership conditions use eq when a single value remains and in
+// otherwise, which also applies to ne and notIn. Whenever the combination
+// cannot be expressed exactly, the first condition is kept and the loss is
+// reported. The result is sorted like SortAndRemoveDuplicateConditions. Any
+// relation other than "or" is treated as "and".
+func MergeDuplicateConditions(a []Condition, relation Relation) ([]Condition, []ConditionMergeLoss) {
+	if len(a) == 0 {
+		return a, nil
+	}
+	if relation != RelationOr {
+		relation = RelationAnd
+	}
+
+	groups := make([][]Condition, 0)
+	groupIndex := make(map[string]int)
+	for _, condition := range a {
+		key := fmt.Sprintf("%s|%s", condition.FieldName, familyOf(condition.Operator))
+		i, ok := groupIndex[key]
+		if !ok {
+			i = len(groups)
+			groupIndex[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], condition)
+	}
+
+	result := make([]Condition, 0, len(groups))
+	losses := make([]ConditionMergeLoss, 0)
+	for _, group := range groups {
+		merged, loss := mergeConditionGroup(group, relation)
+		result = append(result, merged)
+		if loss != nil {
+			losses = append(losses, *loss)
+		}
+	}
+
+	slices.SortStableFunc(result, CompareConditions)
+	return result, losses
+}
+
+func familyOf(operator Operator) operatorFamily {
+	switch operator {
+	case OperatorEq, OperatorIn:
+		return operatorFamilyMembership
+	case OperatorNotEq, OperatorNotIn:
+		return operatorFamilyExclusion
+	}
+	return operatorFamily(operator)
+}
+
+func mergeConditionGroup(group []Condition, relation Relation) (Condition, *ConditionMergeLoss) {
+	first := group[0]
+	if len(group) == 1 {
+		return first, nil
+	}
+
+	if haveSameOperatorAndValue(group) {
+		return first, nil
+	}
+
+	var (
+		merged Condition
+		reason string
+	)
+	switch familyOf(first.Operator) {
+	case operatorFamilyMembership:
+		merged, reason = mergeValueLists(group, relation == RelationOr, OperatorEq, OperatorIn)
+	case operatorFamilyExclusion:
+		merged, reason = mergeValueLists(group, relation == RelationAnd, OperatorNotEq, OperatorNotIn)
+	case operatorFamily(OperatorGt):
+		merged, reason = mergeBounds(group, relation == RelationAnd)
+	case operatorFamily(OperatorLt):
+		merged, reason = mergeBounds(group, relation == RelationOr)
+	case operatorFamily(OperatorBetween):
+		merged, reason = mergeRanges(group, relation)
+	default:
+		reason = fmt.Sprintf("operator %s does not support merging", first.Operator)
+	}
+
+	if reason != "" {
+		return first, &ConditionMergeLoss{
+			FieldName: first.FieldName,
+			Operator:  first.Operator,
+			Kept:      first,
+			Dropped:   append([]Condition{}, group[1:]...),
+			Reason:    reason,
+		}
+	}
+	return merged, nil
+}
+
+func haveSameOperatorAndValue(group []Condition) bool {
+	for _, condition := range group[1:] {
+		if condition.Operator != group[0].Operator || !reflect.DeepEqual(condition.Value, group[0].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeValueLists combines membership values by union or intersection and picks
+// the single or multiple value operator based on how many values remain.
+func mergeValueLists(group []Condition, union bool, single Operator, multiple Operator) (Condition, string) {
+	lists := make([][]interface{}, len(group))
+	for i, condition := range group {
+		values, ok := toValueList(condition.Value)
+		if !ok {
+			return Condition{}, "values have unsupported types"
+		}
+		lists[i] = values
+	}
+
+	values := lists[0]
+	for _, list := range lists[1:] {
+		values = combineValues(values, list, union)
+	}
+	if len(values) == 0 {
+		return Condition{}, "conditions cannot be satisfied together"
+	}
+
+	value, ok := fromValueList(values)
+	if !ok {
+		return Condition{}, "values have different types"
+	}
+
+	condition := Condition{FieldName: group[0].FieldName, Operator: multiple, Value: value}
+	if len(values) == 1 {
+		condition.Operator = single
+		condition.Value = values[0]
+	}
+	return condition, ""
+}
+
+// combineValues mirrors Union and Intersect, which cannot be instantiated with
+// interface{} before go1.20.
+func combineValues(a []interface{}, b []interface{}, union bool) []interface{} {
+	inB := make(map[interface{}]struct{}, len(b))
+	for _, v := range b {
+		inB[v] = struct{}{}
+	}
+
+	seen := make(map[interface{}]struct{}, len(a)+len(b))
+	result := make([]interface{}, 0, len(a)+len(b))
+	for _, v := range a {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		if _, ok := inB[v]; ok || union {
+			result = append(result, v)
+		}
+	}
+	if union {
+		for _, v := range b {
+			if _, ok := seen[v]; !ok {
+				seen[v] = struct{}{}
+				result = append(result, v)
+			}
+		}
+	}
+	return result
+}
+
+func toValueList(value interface{}) ([]interface{}, bool) {
+	switch v := value.(type) {
+	case int, string, uuid.UUID:
+		return []interface{}{v}, true
+	case []int:
+		return toInterfaceSlice(v), true
+	case []string:
+		return toInterfaceSlice(v), true
+	case []uuid.UUID:
+		return toInterfaceSlice(v), true
+	}
+	return nil, false
+}
+
+func toInterfaceSlice[T any](a []T) []interface{} {
+	result := make([]interface{}, len(a))
+	for i, v := range a {
+		result[i] = v
+	}
+	return result
+}
+
+func fromValueList(values []interface{}) (interface{}, bool) {
+	switch values[0].(type) {
+	case int:
+		return fromInterfaceSlice[int](values)
+	case string:
+		return fromInterfaceSlice[string](values)
+	case uuid.UUID:
+		return fromInterfaceSlice[uuid.UUID](values)
+	}
+	return nil, false
+}
+
+func fromInterfaceSlice[T any](values []interface{}) ([]T, bool) {
+	result := make([]T, len(values))
+	for i, value := range values {
+		v, ok := value.(T)
+		if !ok {
+			return nil, false
+		}
+		result[i] = v
+	}
+	return result, true
+}
+
+// mergeBounds keeps the greatest bound when greatest is true and the smallest
+// one otherwise.
+func mergeBounds(group []Condition, greatest bool) (Condition, string) {
+	bound := group[0]
+	for _, condition := range group[1:] {
+		cmp, reason := compareBounds(condition.Value, bound.Value)
+		if reason != "" {
+			return Condition{}, reason
+		}
+		if (greatest && cmp > 0) || (!greatest && cmp < 0) {
+			bound = condition
+		}
+	}
+	return bound, ""
+}
+
+func compareBounds(a interface{}, b interface{}) (int, string) {
+	switch av := a.(type) {
+	case RFCDate:
+		bv, ok := b.(RFCDate)
+		if !ok {
+			return 0, "values have different types"
+		}
+		if !HaveSameElements(av.Format, bv.Format) {
+			return 0, "dates have different formats"
+		}
+		return compareTimes(av, bv), ""
+	case int:
+		bv, ok := b.(int)
+		if !ok {
+			return 0, "values have different types"
+		}
+		return compareInts(av, bv), ""
+	}
+	return 0, "values have unsupported types"
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareTimes(a RFCDate, b RFCDate) int {
+	switch {
+	case a.Date.Before(b.Date):
+		return -1
+	case a.Date.After(b.Date):
+		return 1
+	}
+	return 0
+}
+
+// mergeRanges intersects ranges under "and". Under "or" ranges are joined only
+// when they overlap, since disjoint ranges cannot be expressed by one between.
+func mergeRanges(group []Condition, relation Relation) (Condition, string) {
+	ranges := make([][2]RFCDate, len(group))
+	for i, condition := range group {
+		r, ok := condition.Value.([2]RFCDate)
+		if !ok {
+			return Condition{}, "values have unsupported types"
+		}
+		ranges[i] = r
+	}
+	for _, r := range ranges[1:] {
+		if !HaveSameElements(r[0].Format, ranges[0][0].Format) || !HaveSameElements(r[1].Format, ranges[0][1].Format) {
+			return Condition{}, "dates have different formats"
+		}
+	}
+
+	merged := ranges[0]
+	if relation == RelationAnd {
+		for _, r := range ranges[1:] {
+			if compareTimes(r[0], merged[0]) > 0 {
+				merged[0] = r[0]
+			}
+			if compareTimes(r[1], merged[1]) < 0 {
+				merged[1] = r[1]
+			}
+		}
+		if compareTimes(merged[0], merged[1]) > 0 {
+			return Condition{}, "ranges do not overlap"
+		}
+	} else {
+		slices.SortStableFunc(ranges, func(a, b [2]RFCDate) int { return compareTimes(a[0], b[0]) })
+		merged = ranges[0]
+		for _, r := range ranges[1:] {
+			if compareTimes(r[0], merged[1]) > 0 {
+				return Condition{}, "ranges do not overlap"
+			}
+			if compareTimes(r[1], merged[1]) > 0 {
+				merged[1] = r[1]
+			}
+		}
+	}
+
+	return Condition{FieldName: group[0].FieldName, Operator: OperatorBetween, Value: merged}, ""
+}
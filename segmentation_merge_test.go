@@ -0,0 +1,232 @@
+package utils_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) utils.RFCDate {
+	return utils.RFCDate{
+		Date:   time.Date(year, month, day, 0, 0, 0, 0, time.UTC),
+		Format: []utils.RFCDateFormat{utils.RFCDateFormatDay, utils.RFCDateFormatMonth, utils.RFCDateFormatYear},
+	}
+}
+
+func TestMergeDuplicateConditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		relation   utils.Relation
+		conditions []utils.Condition
+		want       []utils.Condition
+		lossy      bool
+	}{
+		{
+			name:     "in values should be joined under or",
+			relation: utils.RelationOr,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameDepartmentId, Operator: utils.OperatorIn, Value: []int{1, 2}},
+				{FieldName: utils.FieldNameDepartmentId, Operator: utils.OperatorIn, Value: []int{3}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameDepartmentId, Operator: utils.OperatorIn, Value: []int{1, 2, 3}},
+			},
+		},
+		{
+			name:     "eq and in values should be joined under or",
+			relation: utils.RelationOr,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameEmail, Operator: utils.OperatorEq, Value: "a@example.com"},
+				{FieldName: utils.FieldNameEmail, Operator: utils.OperatorIn, Value: []string{"b@example.com", "a@example.com"}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameEmail, Operator: utils.OperatorIn, Value: []string{"a@example.com", "b@example.com"}},
+			},
+		},
+		{
+			name:     "in values should be intersected under and",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameJobId, Operator: utils.OperatorIn, Value: []int{1, 2, 3}},
+				{FieldName: utils.FieldNameJobId, Operator: utils.OperatorIn, Value: []int{2, 3, 4}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameJobId, Operator: utils.OperatorIn, Value: []int{2, 3}},
+			},
+		},
+		{
+			name:     "single remaining value should use eq",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameJobId, Operator: utils.OperatorIn, Value: []int{1, 2}},
+				{FieldName: utils.FieldNameJobId, Operator: utils.OperatorEq, Value: 2},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameJobId, Operator: utils.OperatorEq, Value: 2},
+			},
+		},
+		{
+			name:     "notIn values should be joined under and",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameUnit, Operator: utils.OperatorNotEq, Value: 1},
+				{FieldName: utils.FieldNameUnit, Operator: utils.OperatorNotIn, Value: []int{2}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameUnit, Operator: utils.OperatorNotIn, Value: []int{1, 2}},
+			},
+		},
+		{
+			name:     "gt should keep the tightest bound under and",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorGt, Value: date(2020, 1, 1)},
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorGt, Value: date(2021, 1, 1)},
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorLt, Value: date(2023, 1, 1)},
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorLt, Value: date(2022, 1, 1)},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorGt, Value: date(2021, 1, 1)},
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorLt, Value: date(2022, 1, 1)},
+			},
+		},
+		{
+			name:     "gt should keep the loosest bound under or",
+			relation: utils.RelationOr,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorGt, Value: date(2021, 1, 1)},
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorGt, Value: date(2020, 1, 1)},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameHireDate, Operator: utils.OperatorGt, Value: date(2020, 1, 1)},
+			},
+		},
+		{
+			name:     "int bounds far apart should be compared without overflowing",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorGt, Value: math.MinInt},
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorGt, Value: math.MaxInt},
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorLt, Value: math.MaxInt},
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorLt, Value: -1},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorGt, Value: math.MaxInt},
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorLt, Value: -1},
+			},
+		},
+		{
+			name:     "int bounds far apart should keep the loosest bound under or",
+			relation: utils.RelationOr,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorGt, Value: math.MaxInt - 1},
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorGt, Value: math.MinInt + 1},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameRelationalCustom1, Operator: utils.OperatorGt, Value: math.MinInt + 1},
+			},
+		},
+		{
+			name:     "between ranges should be intersected under and",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1990, 1, 1), date(2000, 1, 1)}},
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1995, 1, 1), date(2005, 1, 1)}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1995, 1, 1), date(2000, 1, 1)}},
+			},
+		},
+		{
+			name:     "overlapping between ranges should be joined under or",
+			relation: utils.RelationOr,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1995, 1, 1), date(2005, 1, 1)}},
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1990, 1, 1), date(2000, 1, 1)}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1990, 1, 1), date(2005, 1, 1)}},
+			},
+		},
+		{
+			name:     "identical conditions should be deduplicated",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameName, Operator: utils.OperatorEq, Value: "John"},
+				{FieldName: utils.FieldNameCity, Operator: utils.OperatorEq, Value: 1},
+				{FieldName: utils.FieldNameName, Operator: utils.OperatorEq, Value: "John"},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameCity, Operator: utils.OperatorEq, Value: 1},
+				{FieldName: utils.FieldNameName, Operator: utils.OperatorEq, Value: "John"},
+			},
+		},
+		{
+			name:     "conflicting eq values under and should be reported",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameDepartmentId, Operator: utils.OperatorEq, Value: 1},
+				{FieldName: utils.FieldNameDepartmentId, Operator: utils.OperatorEq, Value: 2},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameDepartmentId, Operator: utils.OperatorEq, Value: 1},
+			},
+			lossy: true,
+		},
+		{
+			name:     "disjoint between ranges under or should be reported",
+			relation: utils.RelationOr,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1990, 1, 1), date(1991, 1, 1)}},
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(2000, 1, 1), date(2001, 1, 1)}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameBirthday, Operator: utils.OperatorBetween, Value: [2]utils.RFCDate{date(1990, 1, 1), date(1991, 1, 1)}},
+			},
+			lossy: true,
+		},
+		{
+			name:     "dates with different formats should be reported",
+			relation: utils.RelationAnd,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameCreatedAt, Operator: utils.OperatorGt, Value: date(2020, 1, 1)},
+				{FieldName: utils.FieldNameCreatedAt, Operator: utils.OperatorGt, Value: utils.RFCDate{Date: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), Format: []utils.RFCDateFormat{utils.RFCDateFormatYear}}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameCreatedAt, Operator: utils.OperatorGt, Value: date(2020, 1, 1)},
+			},
+			lossy: true,
+		},
+		{
+			name:     "location conditions cannot be merged",
+			relation: utils.RelationOr,
+			conditions: []utils.Condition{
+				{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: 1, Lon: 1, Km: 10}},
+				{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: 2, Lon: 2, Km: 10}},
+			},
+			want: []utils.Condition{
+				{FieldName: utils.FieldNameLocation, Operator: utils.OperatorWithinRadius, Value: utils.GeoRadius{Lat: 1, Lon: 1, Km: 10}},
+			},
+			lossy: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, losses := utils.MergeDuplicateConditions(tt.conditions, tt.relation)
+			assert.Equal(t, tt.want, got)
+
+			if tt.lossy {
+				assert.Len(t, losses, 1)
+				assert.Equal(t, tt.want[0], losses[0].Kept)
+				assert.Equal(t, tt.conditions[1:], losses[0].Dropped)
+				assert.NotEmpty(t, losses[0].Reason)
+			} else {
+				assert.Empty(t, losses)
+			}
+		})
+	}
+}
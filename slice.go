@@ -1,26 +1,58 @@
 package utils
 
 import (
+	"bytes"
 	"sort"
 
 	"github.com/google/uuid"
 	"golang.org/x/exp/constraints"
+	"golang.org/x/exp/slices"
 )
 
+// smallSliceLen is the size up to which comparisons are done without
+// allocating, since quadratic scans beat hashing for a handful of elements.
+const smallSliceLen = 16
+
+// HaveSameElements reports whether both slices hold the same elements with the
+// same multiplicity, regardless of order.
 func HaveSameElements[T comparable](original []T, expected []T) bool {
 	if len(original) != len(expected) {
 		return false
 	}
 
+	if len(original) <= smallSliceLen {
+		for _, e := range original {
+			if count(original, e) != count(expected, e) {
+				return false
+			}
+		}
+		return true
+	}
+
+	counts := make(map[T]int, len(original))
 	for _, e := range original {
-		if !Contains(expected, e) {
+		counts[e]++
+	}
+	for _, e := range expected {
+		if counts[e] == 0 {
 			return false
 		}
+		counts[e]--
 	}
 
 	return true
 }
 
+func count[T comparable](a []T, expected T) int {
+	n := 0
+	for _, e := range a {
+		if e == expected {
+			n++
+		}
+	}
+	return n
+}
+
 func Contains[T comparable](original []T, expected T) bool {
 	for _, e := range original {
 		if e == expected {
@@ -56,17 +88,18 @@ func SortAndRemoveDuplicates[T constraints.Ordered](a []T) []T {
 	return RemoveDuplicatesFromOrderedSlice(a)
 }
 
-func SortAndRemoveDuplicateUUIDs(a []uuid.UUID) []uuid.UUID {
+// SortAndRemoveDuplicatesFunc sorts a in place using cmp and keeps only the
+// first of each group of elements that cmp reports as equal. Sorting is stable,
+// so the kept element is the one that appeared first in a.
+func SortAndRemoveDuplicatesFunc[T any](a []T, cmp func(a, b T) int) []T {
 	if len(a) == 0 {
 		return a
 	}
 
-	sort.Slice(a, func(i, j int) bool {
-		return a[i].String() < a[j].String()
-	})
+	slices.SortStableFunc(a, cmp)
 	j := 0
 	for i := 1; i < len(a); i++ {
-		if a[j].String() != a[i].String() {
+		if cmp(a[j], a[i]) != 0 {
 			j++
 			a[j] = a[i]
 		}
@@ -74,23 +107,215 @@ func SortAndRemoveDuplicateUUIDs(a []uuid.UUID) []uuid.UUID {
 	return a[:j+1]
 }
 
-func SortAndRemoveDuplicateConditions(a []Condition) []Condition {
+// SortAndRemoveDuplicateUUIDs sorts by the raw bytes of the UUIDs, which is the
+// same order as their string representation.
+func SortAndRemoveDuplicateUUIDs(a []uuid.UUID) []uuid.UUID {
 	if len(a) == 0 {
 		return a
 	}
 
-	sort.Slice(a, func(i, j int) bool {
-		if a[i].FieldName == a[j].FieldName {
-			return a[i].Operator < a[j].Operator
-		}
-		return a[i].FieldName < a[j].FieldName
-	})
+	slices.SortFunc(a, CompareUUIDs)
 	j := 0
 	for i := 1; i < len(a); i++ {
-		if a[j].FieldName != a[i].FieldName || a[j].Operator != a[i].Operator {
+		if a[j] != a[i] {
 			j++
 			a[j] = a[i]
 		}
 	}
 	return a[:j+1]
 }
+
+func SortAndRemoveDuplicateConditions(a []Condition) []Condition {
+	return SortAndRemoveDuplicatesFunc(a, CompareConditions)
+}
+
+func CompareUUIDs(a uuid.UUID, b uuid.UUID) int {
+	return bytes.Compare(a[:], b[:])
+}
+
+// CompareConditions orders conditions by field name followed by operator.
+func CompareConditions(a Condition, b Condition) int {
+	if a.FieldName != b.FieldName {
+		if a.FieldName < b.FieldName {
+			return -1
+		}
+		return 1
+	}
+	if a.Operator != b.Operator {
+		if a.Operator < b.Operator {
+			return -1
+		}
+		return 1
+	}
+	return 0
+}
+
+// Union returns the distinct elements of a followed by the distinct elements of
+// b that are not in a, in order of first appearance.
+func Union[T comparable](a []T, b []T) []T {
+	seen := make(map[T]struct{}, len(a)+len(b))
+	result := make([]T, 0, len(a)+len(b))
+	for _, s := range [][]T{a, b} {
+		for _, e := range s {
+			if _, ok := seen[e]; !ok {
+				seen[e] = struct{}{}
+				result = append(result, e)
+			}
+		}
+	}
+	return result
+}
+
+// Intersect returns the distinct elements of a that are also in b, in the order
+// they appear in a.
+func Intersect[T comparable](a []T, b []T) []T {
+	return filterBySet(a, toSet(b), true)
+}
+
+// Difference returns the distinct elements of a that are not in b, in the order
+// they appear in a.
+func Difference[T comparable](a []T, b []T) []T {
+	return filterBySet(a, toSet(b), false)
+}
+
+// SymmetricDifference returns the distinct elements of a that are not in b
+// followed by the distinct elements of b that are not in a.
+func SymmetricDifference[T comparable](a []T, b []T) []T {
+	return append(Difference(a, b), Difference(b, a)...)
+}
+
+// IsSubset reports whether every element of a is also in b.
+func IsSubset[T comparable](a []T, b []T) bool {
+	set := toSet(b)
+	for _, e := range a {
+		if _, ok := set[e]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func toSet[T comparable](a []T) map[T]struct{} {
+	set := make(map[T]struct{}, len(a))
+	for _, e := range a {
+		set[e] = struct{}{}
+	}
+	return set
+}
+
+func filterBySet[T comparable](a []T, set map[T]struct{}, keep bool) []T {
+	seen := make(map[T]struct{}, len(a))
+	result := make([]T, 0)
+	for _, e := range a {
+		if _, ok := seen[e]; ok {
+			continue
+		}
+		seen[e] = struct{}{}
+		if _, ok := set[e]; ok == keep {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// UnionSorted is the merge-based variant of Union for inputs that are sorted and
+// free of duplicates, such as the output of SortAndRemoveDuplicates. The result
+// is sorted as well.
+func UnionSorted[T constraints.Ordered](a []T, b []T) []T {
+	result := make([]T, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+// IntersectSorted is the merge-based variant of Intersect for sorted inputs
+// without duplicates.
+func IntersectSorted[T constraints.Ordered](a []T, b []T) []T {
+	result := make([]T, 0)
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// DifferenceSorted is the merge-based variant of Difference for sorted inputs
+// without duplicates.
+func DifferenceSorted[T constraints.Ordered](a []T, b []T) []T {
+	result := make([]T, 0, len(a))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	return append(result, a[i:]...)
+}
+
+// SymmetricDifferenceSorted is the merge-based variant of SymmetricDifference
+// for sorted inputs without duplicates. The result is sorted.
+func SymmetricDifferenceSorted[T constraints.Ordered](a []T, b []T) []T {
+	result := make([]T, 0)
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			result = append(result, a[i])
+			i++
+		case a[i] > b[j]:
+			result = append(result, b[j])
+			j++
+		default:
+			i++
+			j++
+		}
+	}
+	result = append(result, a[i:]...)
+	return append(result, b[j:]...)
+}
+
+// IsSubsetSorted is the merge-based variant of IsSubset for sorted inputs
+// without duplicates. It does not allocate.
+func IsSubsetSorted[T constraints.Ordered](a []T, b []T) bool {
+	j := 0
+	for _, e := range a {
+		for j < len(b) && b[j] < e {
+			j++
+		}
+		if j == len(b) || b[j] != e {
+			return false
+		}
+		j++
+	}
+	return true
+}
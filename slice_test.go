@@ -3,6 +3,7 @@ package utils_test
 import (
 	"fmt"
 	"reflect"
+	"sort"
 
 	utils "github.com/criticalmassbr/ms-utils"
 	"github.com/google/uuid"
@@ -457,3 +458,270 @@ func TestSortAndRemoveDuplicateConditions(t *testing.T) {
 		})
 	}
 }
+
+func TestHaveSameElements(t *testing.T) {
+	large := make([]int, 100)
+	reversed := make([]int, 100)
+	for i := range large {
+		large[i] = i % 40
+		reversed[len(reversed)-1-i] = i % 40
+	}
+	largeOther := append([]int{}, reversed...)
+	largeOther[0] = largeOther[1]
+
+	tests := []struct {
+		name     string
+		original []string
+		expected []string
+		want     bool
+	}{
+		{
+			name:     "empty slices are equal",
+			original: []string{},
+			expected: []string{},
+			want:     true,
+		},
+		{
+			name:     "order does not matter",
+			original: []string{"a", "b", "c"},
+			expected: []string{"c", "a", "b"},
+			want:     true,
+		},
+		{
+			name:     "different lengths are not equal",
+			original: []string{"a", "b"},
+			expected: []string{"a", "b", "b"},
+			want:     false,
+		},
+		{
+			name:     "multiplicity must match",
+			original: []string{"a", "a", "b"},
+			expected: []string{"a", "b", "b"},
+			want:     false,
+		},
+		{
+			name:     "repeated elements with same multiplicity are equal",
+			original: []string{"a", "b", "a"},
+			expected: []string{"a", "a", "b"},
+			want:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, utils.HaveSameElements(tt.original, tt.expected))
+		})
+	}
+
+	t.Run("large slices", func(t *testing.T) {
+		assert.True(t, utils.HaveSameElements(large, reversed))
+		assert.False(t, utils.HaveSameElements(large, largeOther))
+	})
+}
+
+func TestSetOperations(t *testing.T) {
+	tests := []struct {
+		name                string
+		a                   []int
+		b                   []int
+		union               []int
+		intersect           []int
+		difference          []int
+		symmetricDifference []int
+		isSubset            bool
+	}{
+		{
+			name:                "empty slices",
+			a:                   []int{},
+			b:                   []int{},
+			union:               []int{},
+			intersect:           []int{},
+			difference:          []int{},
+			symmetricDifference: []int{},
+			isSubset:            true,
+		},
+		{
+			name:                "overlapping slices",
+			a:                   []int{1, 2, 3, 5},
+			b:                   []int{2, 4, 5, 6},
+			union:               []int{1, 2, 3, 5, 4, 6},
+			intersect:           []int{2, 5},
+			difference:          []int{1, 3},
+			symmetricDifference: []int{1, 3, 4, 6},
+			isSubset:            false,
+		},
+		{
+			name:                "subset",
+			a:                   []int{2, 3},
+			b:                   []int{1, 2, 3, 4},
+			union:               []int{2, 3, 1, 4},
+			intersect:           []int{2, 3},
+			difference:          []int{},
+			symmetricDifference: []int{1, 4},
+			isSubset:            true,
+		},
+		{
+			name:                "disjoint slices",
+			a:                   []int{1, 2},
+			b:                   []int{3},
+			union:               []int{1, 2, 3},
+			intersect:           []int{},
+			difference:          []int{1, 2},
+			symmetricDifference: []int{1, 2, 3},
+			isSubset:            false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("hash - %s", tt.name), func(t *testing.T) {
+			assert.Equal(t, tt.union, utils.Union(tt.a, tt.b))
+			assert.Equal(t, tt.intersect, utils.Intersect(tt.a, tt.b))
+			assert.Equal(t, tt.difference, utils.Difference(tt.a, tt.b))
+			assert.Equal(t, tt.symmetricDifference, utils.SymmetricDifference(tt.a, tt.b))
+			assert.Equal(t, tt.isSubset, utils.IsSubset(tt.a, tt.b))
+		})
+
+		t.Run(fmt.Sprintf("sorted - %s", tt.name), func(t *testing.T) {
+			assert.Equal(t, utils.SortAndRemoveDuplicates(append([]int{}, tt.union...)), utils.UnionSorted(tt.a, tt.b))
+			assert.Equal(t, tt.intersect, utils.IntersectSorted(tt.a, tt.b))
+			assert.Equal(t, tt.difference, utils.DifferenceSorted(tt.a, tt.b))
+			assert.Equal(t, utils.SortAndRemoveDuplicates(append([]int{}, tt.symmetricDifference...)), utils.SymmetricDifferenceSorted(tt.a, tt.b))
+			assert.Equal(t, tt.isSubset, utils.IsSubsetSorted(tt.a, tt.b))
+		})
+	}
+
+	t.Run("hash variants should remove duplicates", func(t *testing.T) {
+		assert.Equal(t, []string{"a", "b", "c"}, utils.Union([]string{"a", "a", "b"}, []string{"c", "b", "c"}))
+		assert.Equal(t, []string{"a"}, utils.Intersect([]string{"a", "a", "b"}, []string{"a", "c"}))
+		assert.Equal(t, []string{"b"}, utils.Difference([]string{"a", "b", "b"}, []string{"a"}))
+	})
+}
+
+func BenchmarkHaveSameElements(b *testing.B) {
+	original := make([]int, 10000)
+	expected := make([]int, 10000)
+	for i := range original {
+		original[i] = i
+		expected[len(expected)-1-i] = i
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		utils.HaveSameElements(original, expected)
+	}
+}
+
+func BenchmarkIntersect(b *testing.B) {
+	first := make([]int, 10000)
+	second := make([]int, 10000)
+	for i := range first {
+		first[i] = i * 2
+		second[i] = i * 3
+	}
+
+	b.Run("hash", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			utils.Intersect(first, second)
+		}
+	})
+
+	b.Run("sorted", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			utils.IntersectSorted(first, second)
+		}
+	})
+}
+
+func TestSortAndRemoveDuplicatesFunc(t *testing.T) {
+	type employee struct {
+		id   int
+		name string
+	}
+
+	t.Run("should keep the first element of each duplicate group", func(t *testing.T) {
+		got := utils.SortAndRemoveDuplicatesFunc([]employee{
+			{id: 3, name: "c"},
+			{id: 1, name: "a"},
+			{id: 3, name: "c2"},
+			{id: 2, name: "b"},
+			{id: 1, name: "a2"},
+		}, func(a, b employee) int { return a.id - b.id })
+
+		assert.Equal(t, []employee{{id: 1, name: "a"}, {id: 2, name: "b"}, {id: 3, name: "c"}}, got)
+	})
+
+	t.Run("empty slice should return an empty slice", func(t *testing.T) {
+		got := utils.SortAndRemoveDuplicatesFunc([]employee{}, func(a, b employee) int { return a.id - b.id })
+		assert.Equal(t, []employee{}, got)
+	})
+
+	t.Run("should match string ordering for uuids", func(t *testing.T) {
+		uuids := randomUUIDs(1000, 100)
+		want := sortAndRemoveDuplicateUUIDsByString(append([]uuid.UUID{}, uuids...))
+
+		assert.Equal(t, want, utils.SortAndRemoveDuplicateUUIDs(append([]uuid.UUID{}, uuids...)))
+		assert.Equal(t, want, utils.SortAndRemoveDuplicatesFunc(append([]uuid.UUID{}, uuids...), utils.CompareUUIDs))
+	})
+}
+
+func randomUUIDs(n int, distinct int) []uuid.UUID {
+	pool := make([]uuid.UUID, distinct)
+	for i := range pool {
+		pool[i] = uuid.New()
+	}
+
+	uuids := make([]uuid.UUID, n)
+	for i := range uuids {
+		uuids[i] = pool[(i*7919)%distinct]
+	}
+	return uuids
+}
+
+// sortAndRemoveDuplicateUUIDsByString is the previous string based
+// implementation, kept as a reference for benchmarks.
+func sortAndRemoveDuplicateUUIDsByString(a []uuid.UUID) []uuid.UUID {
+	if len(a) == 0 {
+		return a
+	}
+
+	sort.Slice(a, func(i, j int) bool {
+		return a[i].String() < a[j].String()
+	})
+	j := 0
+	for i := 1; i < len(a); i++ {
+		if a[j].String() != a[i].String() {
+			j++
+			a[j] = a[i]
+		}
+	}
+	return a[:j+1]
+}
+
+func BenchmarkSortAndRemoveDuplicateUUIDs(b *testing.B) {
+	uuids := randomUUIDs(100000, 80000)
+	input := make([]uuid.UUID, len(uuids))
+
+	b.Run("string", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			copy(input, uuids)
+			sortAndRemoveDuplicateUUIDsByString(input)
+		}
+	})
+
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			copy(input, uuids)
+			utils.SortAndRemoveDuplicateUUIDs(input)
+		}
+	})
+
+	b.Run("func", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			copy(input, uuids)
+			utils.SortAndRemoveDuplicatesFunc(input, utils.CompareUUIDs)
+		}
+	})
+}
@@ -0,0 +1,313 @@
+package typed_sync_map
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"unsafe"
+
+	"github.com/google/uuid"
+)
+
+// Map is the API shared by TypedSyncMap and ShardedMap.
+type Map[K comparable, V any] interface {
+	Load(key K) (V, bool)
+	Store(key K, val V)
+	Delete(key K)
+	LoadOrStore(key K, val V) (V, bool)
+	LoadAndDelete(key K) (V, bool)
+	Swap(key K, val V) (V, bool)
+	CompareAndSwap(key K, old V, new V) bool
+	CompareAndDelete(key K, old V) bool
+	Range(f func(key K, value V) bool)
+	Len() int
+	Keys() []K
+	Values() []V
+	Clear()
+}
+
+var (
+	_ Map[string, int] = (*TypedSyncMap[string, int])(nil)
+	_ Map[string, int] = (*ShardedMap[string, int])(nil)
+)
+
+const defaultShardCount = 32
+
+type ShardedMapConfig[K comparable] struct {
+	// ShardCount defaults to 32 if not set
+	ShardCount int
+	// Hash defaults to DefaultHash. Provide one for key types DefaultHash does
+	// not handle natively, since its fallback formats the key on every call.
+	Hash func(key K) uint64
+}
+
+// ShardedMap splits its entries among maps guarded by their own RWMutex, so
+// writes to different shards do not contend. Prefer it over TypedSyncMap for
+// write-heavy workloads or keys that are frequently overwritten, such as
+// counters and connection registries. TypedSyncMap remains the better choice
+// for read-mostly maps whose keys are written once, like caches of tenant
+// configuration. See the benchmarks in sharded_map_test.go.
+type ShardedMap[K comparable, V any] struct {
+	shards []shard[K, V]
+	hash   func(key K) uint64
+}
+
+// shardSize is the size of the fields of a shard, which does not depend on its
+// type parameters since a map is a pointer.
+const shardSize = unsafe.Sizeof(struct {
+	sync.RWMutex
+	m map[int]int
+}{})
+
+type shard[K comparable, V any] struct {
+	sync.RWMutex
+	m map[K]V
+	// pads shards to a multiple of 64 bytes so they don't share cache lines
+	_ [(64 - shardSize%64) % 64]byte
+}
+
+func NewShardedMap[K comparable, V any](config ShardedMapConfig[K]) *ShardedMap[K, V] {
+	if config.ShardCount <= 0 {
+		config.ShardCount = defaultShardCount
+	}
+	if config.Hash == nil {
+		config.Hash = DefaultHash[K]
+	}
+
+	m := &ShardedMap[K, V]{
+		shards: make([]shard[K, V], config.ShardCount),
+		hash:   config.Hash,
+	}
+	for i := range m.shards {
+		m.shards[i].m = make(map[K]V)
+	}
+	return m
+}
+
+func (m *ShardedMap[K, V]) shardFor(key K) *shard[K, V] {
+	return &m.shards[m.hash(key)%uint64(len(m.shards))]
+}
+
+func (m *ShardedMap[K, V]) Load(key K) (V, bool) {
+	s := m.shardFor(key)
+	s.RLock()
+	defer s.RUnlock()
+	value, ok := s.m[key]
+	return value, ok
+}
+
+func (m *ShardedMap[K, V]) Store(key K, val V) {
+	s := m.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	s.m[key] = val
+}
+
+func (m *ShardedMap[K, V]) Delete(key K) {
+	s := m.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	delete(s.m, key)
+}
+
+func (m *ShardedMap[K, V]) LoadOrStore(key K, val V) (V, bool) {
+	s := m.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	if value, ok := s.m[key]; ok {
+		return value, true
+	}
+	s.m[key] = val
+	return val, false
+}
+
+func (m *ShardedMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	s := m.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	value, ok := s.m[key]
+	delete(s.m, key)
+	return value, ok
+}
+
+func (m *ShardedMap[K, V]) Swap(key K, val V) (V, bool) {
+	s := m.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	previous, ok := s.m[key]
+	s.m[key] = val
+	return previous, ok
+}
+
+// CompareAndSwap panics if V is not comparable, like TypedSyncMap.
+func (m *ShardedMap[K, V]) CompareAndSwap(key K, old V, new V) bool {
+	s := m.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	current, ok := s.m[key]
+	if !ok || any(current) != any(old) {
+		return false
+	}
+	s.m[key] = new
+	return true
+}
+
+// CompareAndDelete panics if V is not comparable, like TypedSyncMap.
+func (m *ShardedMap[K, V]) CompareAndDelete(key K, old V) bool {
+	s := m.shardFor(key)
+	s.Lock()
+	defer s.Unlock()
+	current, ok := s.m[key]
+	if !ok || any(current) != any(old) {
+		return false
+	}
+	delete(s.m, key)
+	return true
+}
+
+// Range calls f for a snapshot of each shard taken under its read lock, so f
+// may safely modify the map. Entries changed concurrently may or may not be
+// visited.
+func (m *ShardedMap[K, V]) Range(f func(key K, value V) bool) {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.RLock()
+		keys := make([]K, 0, len(s.m))
+		values := make([]V, 0, len(s.m))
+		for key, value := range s.m {
+			keys = append(keys, key)
+			values = append(values, value)
+		}
+		s.RUnlock()
+
+		for j := range keys {
+			if !f(keys[j], values[j]) {
+				return
+			}
+		}
+	}
+}
+
+func (m *ShardedMap[K, V]) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.RLock()
+		n += len(s.m)
+		s.RUnlock()
+	}
+	return n
+}
+
+func (m *ShardedMap[K, V]) Keys() []K {
+	keys := make([]K, 0)
+	m.Range(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (m *ShardedMap[K, V]) Values() []V {
+	values := make([]V, 0)
+	m.Range(func(_ K, value V) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+func (m *ShardedMap[K, V]) Clear() {
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.Lock()
+		s.m = make(map[K]V)
+		s.Unlock()
+	}
+}
+
+const (
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// DefaultHash hashes strings, integers, floats and UUIDs without allocating.
+// Floats are hashed by value, so -0 and 0 find the same entry. Other key types
+// fall back to hashing their fmt representation, which tells -0 and 0 apart,
+// so keys holding floats, such as structs, need a custom Hash.
+func DefaultHash[K comparable](key K) uint64 {
+	switch k := any(key).(type) {
+	case string:
+		return hashString(k)
+	case int:
+		return mix64(uint64(k))
+	case int8:
+		return mix64(uint64(k))
+	case int16:
+		return mix64(uint64(k))
+	case int32:
+		return mix64(uint64(k))
+	case int64:
+		return mix64(uint64(k))
+	case uint:
+		return mix64(uint64(k))
+	case uint8:
+		return mix64(uint64(k))
+	case uint16:
+		return mix64(uint64(k))
+	case uint32:
+		return mix64(uint64(k))
+	case uint64:
+		return mix64(k)
+	case uintptr:
+		return mix64(uint64(k))
+	case float32:
+		return hashFloat(float64(k))
+	case float64:
+		return hashFloat(k)
+	case complex64:
+		return hashFloat(float64(real(k)))*fnvPrime64 ^ hashFloat(float64(imag(k)))
+	case complex128:
+		return hashFloat(real(k))*fnvPrime64 ^ hashFloat(imag(k))
+	case uuid.UUID:
+		return hashBytes(k[:])
+	}
+	return hashString(fmt.Sprintf("%#v", key))
+}
+
+func hashString(s string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := 0; i < len(s); i++ {
+		h ^= uint64(s[i])
+		h *= fnvPrime64
+	}
+	return h
+}
+
+func hashBytes(b []byte) uint64 {
+	h := uint64(fnvOffset64)
+	for _, c := range b {
+		h ^= uint64(c)
+		h *= fnvPrime64
+	}
+	return h
+}
+
+func hashFloat(f float64) uint64 {
+	if f == 0 {
+		// -0 == 0, but their bits differ
+		f = 0
+	}
+	return mix64(math.Float64bits(f))
+}
+
+// mix64 is the splitmix64 finalizer, which spreads sequential integers across
+// shards.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
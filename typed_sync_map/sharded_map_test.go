@@ -0,0 +1,282 @@
+package typed_sync_map_test
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/criticalmassbr/ms-utils/typed_sync_map"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedMap(t *testing.T) {
+	newMap := func() *typed_sync_map.ShardedMap[string, int] {
+		return typed_sync_map.NewShardedMap[string, int](typed_sync_map.ShardedMapConfig[string]{ShardCount: 4})
+	}
+
+	t.Run("Load should return stored values", func(t *testing.T) {
+		m := newMap()
+		m.Store("a", 1)
+
+		value, ok := m.Load("a")
+		assert.True(t, ok)
+		assert.Equal(t, 1, value)
+
+		_, ok = m.Load("missing")
+		assert.False(t, ok)
+	})
+
+	t.Run("Delete should remove the key", func(t *testing.T) {
+		m := newMap()
+		m.Store("a", 1)
+		m.Delete("a")
+
+		_, ok := m.Load("a")
+		assert.False(t, ok)
+	})
+
+	t.Run("LoadOrStore should only store missing keys", func(t *testing.T) {
+		m := newMap()
+
+		value, loaded := m.LoadOrStore("a", 1)
+		assert.False(t, loaded)
+		assert.Equal(t, 1, value)
+
+		value, loaded = m.LoadOrStore("a", 2)
+		assert.True(t, loaded)
+		assert.Equal(t, 1, value)
+	})
+
+	t.Run("LoadAndDelete should return the removed value", func(t *testing.T) {
+		m := newMap()
+		m.Store("a", 1)
+
+		value, loaded := m.LoadAndDelete("a")
+		assert.True(t, loaded)
+		assert.Equal(t, 1, value)
+
+		_, loaded = m.LoadAndDelete("a")
+		assert.False(t, loaded)
+	})
+
+	t.Run("Swap should return the previous value", func(t *testing.T) {
+		m := newMap()
+
+		_, loaded := m.Swap("a", 1)
+		assert.False(t, loaded)
+
+		previous, loaded := m.Swap("a", 2)
+		assert.True(t, loaded)
+		assert.Equal(t, 1, previous)
+	})
+
+	t.Run("CompareAndSwap and CompareAndDelete should compare the current value", func(t *testing.T) {
+		m := newMap()
+		m.Store("a", 1)
+
+		assert.False(t, m.CompareAndSwap("a", 2, 3))
+		assert.True(t, m.CompareAndSwap("a", 1, 3))
+		assert.False(t, m.CompareAndSwap("missing", 0, 1))
+		assert.False(t, m.CompareAndDelete("a", 1))
+		assert.True(t, m.CompareAndDelete("a", 3))
+		assert.Equal(t, 0, m.Len())
+	})
+
+	t.Run("CompareAndSwap should panic for non comparable values", func(t *testing.T) {
+		m := typed_sync_map.NewShardedMap[string, []int](typed_sync_map.ShardedMapConfig[string]{})
+		m.Store("a", []int{1})
+
+		assert.Panics(t, func() { m.CompareAndSwap("a", []int{1}, []int{2}) })
+	})
+
+	t.Run("Range, Keys and Values should visit every entry", func(t *testing.T) {
+		m := newMap()
+		for i := 0; i < 20; i++ {
+			m.Store(strconv.Itoa(i), i)
+		}
+
+		visited := 0
+		m.Range(func(key string, value int) bool {
+			assert.Equal(t, strconv.Itoa(value), key)
+			visited++
+			return true
+		})
+		assert.Equal(t, 20, visited)
+		assert.Equal(t, 20, m.Len())
+
+		values := m.Values()
+		sort.Ints(values)
+		assert.Equal(t, 0, values[0])
+		assert.Equal(t, 19, values[19])
+		assert.Len(t, m.Keys(), 20)
+	})
+
+	t.Run("Range should stop when f returns false", func(t *testing.T) {
+		m := newMap()
+		for i := 0; i < 20; i++ {
+			m.Store(strconv.Itoa(i), i)
+		}
+
+		visited := 0
+		m.Range(func(_ string, _ int) bool {
+			visited++
+			return visited < 3
+		})
+		assert.Equal(t, 3, visited)
+	})
+
+	t.Run("Range should allow modifying the map", func(t *testing.T) {
+		m := newMap()
+		for i := 0; i < 20; i++ {
+			m.Store(strconv.Itoa(i), i)
+		}
+
+		m.Range(func(key string, _ int) bool {
+			m.Delete(key)
+			return true
+		})
+		assert.Equal(t, 0, m.Len())
+	})
+
+	t.Run("Clear should remove every entry", func(t *testing.T) {
+		m := newMap()
+		m.Store("a", 1)
+		m.Store("b", 2)
+		m.Clear()
+
+		assert.Equal(t, 0, m.Len())
+		assert.Empty(t, m.Keys())
+	})
+
+	t.Run("should use the configured hash function", func(t *testing.T) {
+		var calls int32
+		m := typed_sync_map.NewShardedMap[string, int](typed_sync_map.ShardedMapConfig[string]{
+			ShardCount: 2,
+			Hash: func(key string) uint64 {
+				atomic.AddInt32(&calls, 1)
+				return uint64(len(key))
+			},
+		})
+		m.Store("a", 1)
+		m.Store("bb", 2)
+
+		value, ok := m.Load("bb")
+		assert.True(t, ok)
+		assert.Equal(t, 2, value)
+		assert.Equal(t, int32(3), calls)
+	})
+
+	t.Run("should be safe for concurrent use", func(t *testing.T) {
+		m := typed_sync_map.NewShardedMap[int, int](typed_sync_map.ShardedMapConfig[int]{})
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					m.Store(g*1000+i, i)
+					m.Load(i)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		assert.Equal(t, 8000, m.Len())
+	})
+}
+
+func TestDefaultHash(t *testing.T) {
+	type point struct{ X, Y int }
+	id := uuid.New()
+
+	tests := []struct {
+		name string
+		hash func() (uint64, uint64)
+	}{
+		{"string", func() (uint64, uint64) {
+			return typed_sync_map.DefaultHash("key"), typed_sync_map.DefaultHash("key")
+		}},
+		{"int", func() (uint64, uint64) {
+			return typed_sync_map.DefaultHash(42), typed_sync_map.DefaultHash(42)
+		}},
+		{"uuid", func() (uint64, uint64) {
+			return typed_sync_map.DefaultHash(id), typed_sync_map.DefaultHash(id)
+		}},
+		{"struct", func() (uint64, uint64) {
+			return typed_sync_map.DefaultHash(point{1, 2}), typed_sync_map.DefaultHash(point{1, 2})
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name+" should be deterministic", func(t *testing.T) {
+			a, b := tt.hash()
+			assert.Equal(t, a, b)
+		})
+	}
+
+	t.Run("should spread sequential integers", func(t *testing.T) {
+		shards := make(map[uint64]struct{})
+		for i := 0; i < 64; i++ {
+			shards[typed_sync_map.DefaultHash(i)%8] = struct{}{}
+		}
+		assert.Len(t, shards, 8)
+	})
+
+	t.Run("should distinguish struct keys", func(t *testing.T) {
+		assert.NotEqual(t, typed_sync_map.DefaultHash(point{1, 2}), typed_sync_map.DefaultHash(point{2, 1}))
+	})
+
+	t.Run("should hash floats by value", func(t *testing.T) {
+		negativeZero := math.Copysign(0, -1)
+		assert.Equal(t, typed_sync_map.DefaultHash(0.0), typed_sync_map.DefaultHash(negativeZero))
+		assert.Equal(t, typed_sync_map.DefaultHash(complex(0, 0)), typed_sync_map.DefaultHash(complex(negativeZero, negativeZero)))
+		assert.NotEqual(t, typed_sync_map.DefaultHash(1.0), typed_sync_map.DefaultHash(2.0))
+
+		m := typed_sync_map.NewShardedMap[float64, string](typed_sync_map.ShardedMapConfig[float64]{})
+		m.Store(0, "zero")
+		value, ok := m.Load(negativeZero)
+		assert.True(t, ok)
+		assert.Equal(t, "zero", value)
+	})
+}
+
+// benchmarkMixed runs a workload where writePercent of the operations store a
+// key and the rest load one.
+func benchmarkMixed(b *testing.B, m typed_sync_map.Map[int, int], writePercent int) {
+	const keys = 1 << 12
+	for i := 0; i < keys; i++ {
+		m.Store(i, i)
+	}
+
+	var seed int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(atomic.AddInt64(&seed, 1)) * 7919
+		for pb.Next() {
+			i++
+			key := i % keys
+			if i%100 < writePercent {
+				m.Store(key, i)
+			} else {
+				m.Load(key)
+			}
+		}
+	})
+}
+
+func BenchmarkMaps(b *testing.B) {
+	for _, writePercent := range []int{1, 10, 50, 90} {
+		b.Run(fmt.Sprintf("TypedSyncMap/writes=%d%%", writePercent), func(b *testing.B) {
+			benchmarkMixed(b, &typed_sync_map.TypedSyncMap[int, int]{}, writePercent)
+		})
+		b.Run(fmt.Sprintf("ShardedMap/writes=%d%%", writePercent), func(b *testing.B) {
+			benchmarkMixed(b, typed_sync_map.NewShardedMap[int, int](typed_sync_map.ShardedMapConfig[int]{}), writePercent)
+		})
+	}
+}
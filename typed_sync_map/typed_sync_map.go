@@ -2,18 +2,113 @@ package typed_sync_map
 
 import "sync"
 
+// TypedSyncMap is a type safe wrapper around sync.Map. Its zero value is empty
+// and ready for use, and it must not be copied after first use.
+//
+// Values can only be stored through typed methods, so a present key always
+// holds a V. Methods that return a value and a boolean report false only when
+// the key is absent, and a nil stored for an interface type V is returned as
+// the zero V.
 type TypedSyncMap[K comparable, V any] struct {
 	syncMap sync.Map
 }
 
 func (m *TypedSyncMap[K, V]) Load(key K) (V, bool) {
 	value, hasKey := m.syncMap.Load(key)
-
-	castedValue, isOfCorrectType := value.(V)
-
-	return castedValue, hasKey && isOfCorrectType
+	return cast[V](value, hasKey)
 }
 
 func (m *TypedSyncMap[K, V]) Store(key K, val V) {
 	m.syncMap.Store(key, val)
 }
+
+func (m *TypedSyncMap[K, V]) Delete(key K) {
+	m.syncMap.Delete(key)
+}
+
+// LoadOrStore returns the existing value for the key if present. Otherwise, it
+// stores and returns the given value. The loaded result is true if the value
+// was loaded, false if stored.
+func (m *TypedSyncMap[K, V]) LoadOrStore(key K, val V) (V, bool) {
+	actual, loaded := m.syncMap.LoadOrStore(key, val)
+	value, _ := cast[V](actual, true)
+	return value, loaded
+}
+
+// LoadAndDelete deletes the value for a key, returning the previous value if
+// any. The loaded result reports whether the key was present.
+func (m *TypedSyncMap[K, V]) LoadAndDelete(key K) (V, bool) {
+	value, loaded := m.syncMap.LoadAndDelete(key)
+	return cast[V](value, loaded)
+}
+
+// Swap stores a value for the key and returns the previous value if any. The
+// loaded result reports whether the key was present.
+func (m *TypedSyncMap[K, V]) Swap(key K, val V) (V, bool) {
+	previous, loaded := m.syncMap.Swap(key, val)
+	return cast[V](previous, loaded)
+}
+
+// CompareAndSwap swaps the old and new values for the key if the value stored
+// in the map is equal to old. Like sync.Map, it panics if V is not comparable.
+func (m *TypedSyncMap[K, V]) CompareAndSwap(key K, old V, new V) bool {
+	return m.syncMap.CompareAndSwap(key, old, new)
+}
+
+// CompareAndDelete deletes the entry for the key if its value is equal to old.
+// Like sync.Map, it panics if V is not comparable.
+func (m *TypedSyncMap[K, V]) CompareAndDelete(key K, old V) bool {
+	return m.syncMap.CompareAndDelete(key, old)
+}
+
+// Range calls f sequentially for each key and value present in the map. If f
+// returns false, Range stops the iteration. It has the same consistency
+// guarantees as sync.Map.Range.
+func (m *TypedSyncMap[K, V]) Range(f func(key K, value V) bool) {
+	m.syncMap.Range(func(key, value any) bool {
+		castedValue, _ := cast[V](value, true)
+		return f(key.(K), castedValue)
+	})
+}
+
+// Len counts the entries by ranging over the map, so it is O(n) and may not
+// reflect concurrent updates.
+func (m *TypedSyncMap[K, V]) Len() int {
+	n := 0
+	m.syncMap.Range(func(_, _ any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+func (m *TypedSyncMap[K, V]) Keys() []K {
+	keys := make([]K, 0)
+	m.Range(func(key K, _ V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (m *TypedSyncMap[K, V]) Values() []V {
+	values := make([]V, 0)
+	m.Range(func(_ K, value V) bool {
+		values = append(values, value)
+		return true
+	})
+	return values
+}
+
+// Clear deletes every entry present when it starts ranging over the map.
+func (m *TypedSyncMap[K, V]) Clear() {
+	m.syncMap.Range(func(key, _ any) bool {
+		m.syncMap.Delete(key)
+		return true
+	})
+}
+
+func cast[V any](value any, present bool) (V, bool) {
+	castedValue, isOfCorrectType := value.(V)
+	return castedValue, present && (isOfCorrectType || value == nil)
+}
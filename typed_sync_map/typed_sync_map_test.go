@@ -0,0 +1,134 @@
+package typed_sync_map_test
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/criticalmassbr/ms-utils/typed_sync_map"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypedSyncMap(t *testing.T) {
+	t.Run("Load should report missing keys", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, int]
+
+		value, ok := m.Load("missing")
+		assert.False(t, ok)
+		assert.Equal(t, 0, value)
+	})
+
+	t.Run("Load should return stored nil interface values", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, error]
+		m.Store("nil", nil)
+
+		value, ok := m.Load("nil")
+		assert.True(t, ok)
+		assert.Nil(t, value)
+	})
+
+	t.Run("Delete should remove the key", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, int]
+		m.Store("a", 1)
+		m.Delete("a")
+
+		_, ok := m.Load("a")
+		assert.False(t, ok)
+	})
+
+	t.Run("LoadOrStore should only store missing keys", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, int]
+
+		value, loaded := m.LoadOrStore("a", 1)
+		assert.False(t, loaded)
+		assert.Equal(t, 1, value)
+
+		value, loaded = m.LoadOrStore("a", 2)
+		assert.True(t, loaded)
+		assert.Equal(t, 1, value)
+	})
+
+	t.Run("LoadAndDelete should return the deleted value", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, int]
+		m.Store("a", 1)
+
+		value, loaded := m.LoadAndDelete("a")
+		assert.True(t, loaded)
+		assert.Equal(t, 1, value)
+
+		value, loaded = m.LoadAndDelete("a")
+		assert.False(t, loaded)
+		assert.Equal(t, 0, value)
+	})
+
+	t.Run("Swap should return the previous value", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, int]
+
+		previous, loaded := m.Swap("a", 1)
+		assert.False(t, loaded)
+		assert.Equal(t, 0, previous)
+
+		previous, loaded = m.Swap("a", 2)
+		assert.True(t, loaded)
+		assert.Equal(t, 1, previous)
+	})
+
+	t.Run("CompareAndSwap and CompareAndDelete should compare the current value", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, int]
+		m.Store("a", 1)
+
+		assert.False(t, m.CompareAndSwap("a", 2, 3))
+		assert.True(t, m.CompareAndSwap("a", 1, 3))
+		assert.False(t, m.CompareAndDelete("a", 1))
+		assert.True(t, m.CompareAndDelete("a", 3))
+
+		_, ok := m.Load("a")
+		assert.False(t, ok)
+	})
+
+	t.Run("CompareAndSwap should panic for non comparable values", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, []int]
+		m.Store("a", []int{1})
+
+		assert.Panics(t, func() { m.CompareAndSwap("a", []int{1}, []int{2}) })
+	})
+
+	t.Run("Range, Len, Keys and Values should visit every entry", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, int]
+		m.Store("a", 1)
+		m.Store("b", 2)
+		m.Store("c", 3)
+
+		visited := map[string]int{}
+		m.Range(func(key string, value int) bool {
+			visited[key] = value
+			return true
+		})
+		assert.Equal(t, map[string]int{"a": 1, "b": 2, "c": 3}, visited)
+
+		count := 0
+		m.Range(func(key string, value int) bool {
+			count++
+			return false
+		})
+		assert.Equal(t, 1, count)
+
+		keys := m.Keys()
+		sort.Strings(keys)
+		values := m.Values()
+		sort.Ints(values)
+		assert.Equal(t, 3, m.Len())
+		assert.Equal(t, []string{"a", "b", "c"}, keys)
+		assert.Equal(t, []int{1, 2, 3}, values)
+	})
+
+	t.Run("Clear should remove every entry", func(t *testing.T) {
+		var m typed_sync_map.TypedSyncMap[string, error]
+		m.Store("a", errors.New("a"))
+		m.Store("b", nil)
+		m.Clear()
+
+		assert.Equal(t, 0, m.Len())
+		assert.Equal(t, []string{}, m.Keys())
+	})
+}
@@ -0,0 +1,222 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/hashicorp/vault/api"
+	auth "github.com/hashicorp/vault/api/auth/approle"
+)
+
+type VaultAuthMethod string
+
+const (
+	VaultAuthMethodAppRole    VaultAuthMethod = "approle"
+	VaultAuthMethodKubernetes VaultAuthMethod = "kubernetes"
+	VaultAuthMethodToken      VaultAuthMethod = "token"
+	VaultAuthMethodJWT        VaultAuthMethod = "jwt"
+)
+
+const defaultKubernetesTokenFile = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+var ErrInvalidAuthConfig = errors.New("invalid Vault auth config")
+
+type VaultAppRoleAuthConfig struct {
+	// WrappedSecretIdFile is read on every login instead of using SecretId. It
+	// must hold a response wrapping token for the secret id, and since wrapping
+	// tokens can only be unwrapped once, it must be replaced before the next
+	// login, e.g. by the Vault agent.
+	WrappedSecretIdFile string `koanf:"wrapped_secret_id_file"`
+	// MountPath defaults to "approle"
+	MountPath string `koanf:"mount_path"`
+}
+
+type VaultKubernetesAuthConfig struct {
+	Role string `koanf:"role"`
+	// TokenFile defaults to the service account token mounted in every pod. It
+	// is read on every login, so projected tokens can be rotated.
+	TokenFile string `koanf:"token_file"`
+	// MountPath defaults to "kubernetes"
+	MountPath string `koanf:"mount_path"`
+}
+
+type VaultTokenAuthConfig struct {
+	// Token defaults to the VAULT_TOKEN environment variable.
+	Token string `koanf:"token"`
+}
+
+type VaultJWTAuthConfig struct {
+	Role string `koanf:"role"`
+	// Token is used when TokenFile is not set. TokenFile is read on every
+	// login.
+	Token     string `koanf:"token"`
+	TokenFile string `koanf:"token_file"`
+	// MountPath defaults to "jwt"
+	MountPath string `koanf:"mount_path"`
+}
+
+// ValidateAuth checks that the fields required by the auth method are set.
+func (cfg *VaultConfig) ValidateAuth() error {
+	var missing []string
+	switch cfg.authMethod() {
+	case VaultAuthMethodAppRole:
+		if cfg.RoleId == "" {
+			missing = append(missing, "role_id")
+		}
+		if cfg.SecretId == "" && cfg.AppRole.WrappedSecretIdFile == "" {
+			missing = append(missing, "secret_id or approle.wrapped_secret_id_file")
+		}
+	case VaultAuthMethodKubernetes:
+		if cfg.Kubernetes.Role == "" {
+			missing = append(missing, "kubernetes.role")
+		}
+	case VaultAuthMethodToken:
+		if cfg.Token.Token == "" && os.Getenv(api.EnvVaultToken) == "" {
+			missing = append(missing, fmt.Sprintf("token.token or %s", api.EnvVaultToken))
+		}
+	case VaultAuthMethodJWT:
+		if cfg.JWT.Role == "" {
+			missing = append(missing, "jwt.role")
+		}
+		if cfg.JWT.Token == "" && cfg.JWT.TokenFile == "" {
+			missing = append(missing, "jwt.token or jwt.token_file")
+		}
+	default:
+		return fmt.Errorf("%w: unknown auth method %q", ErrInvalidAuthConfig, cfg.AuthMethod)
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%w: %s auth requires %s", ErrInvalidAuthConfig, cfg.authMethod(), strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// RegisterVaultConfigValidation makes validate run ValidateAuth for every
+// VaultConfig, so configs loaded through configloader are checked per method.
+func RegisterVaultConfigValidation(validate *validator.Validate) {
+	validate.RegisterStructValidation(func(sl validator.StructLevel) {
+		cfg := sl.Current().Interface().(VaultConfig)
+		if err := cfg.ValidateAuth(); err != nil {
+			sl.ReportError(cfg.AuthMethod, "AuthMethod", "AuthMethod", "vault_auth", err.Error())
+		}
+	}, VaultConfig{})
+}
+
+func (cfg *VaultConfig) authMethod() VaultAuthMethod {
+	if cfg.AuthMethod == "" {
+		return VaultAuthMethodAppRole
+	}
+	return cfg.AuthMethod
+}
+
+func newAuthMethod(cfg *VaultConfig) (api.AuthMethod, error) {
+	if err := cfg.ValidateAuth(); err != nil {
+		return nil, err
+	}
+
+	switch cfg.authMethod() {
+	case VaultAuthMethodKubernetes:
+		return &jwtAuth{
+			mountPath: withDefault(cfg.Kubernetes.MountPath, "kubernetes"),
+			role:      cfg.Kubernetes.Role,
+			tokenFile: withDefault(cfg.Kubernetes.TokenFile, defaultKubernetesTokenFile),
+		}, nil
+	case VaultAuthMethodToken:
+		return &tokenAuth{token: withDefault(cfg.Token.Token, os.Getenv(api.EnvVaultToken))}, nil
+	case VaultAuthMethodJWT:
+		return &jwtAuth{
+			mountPath: withDefault(cfg.JWT.MountPath, "jwt"),
+			role:      cfg.JWT.Role,
+			token:     cfg.JWT.Token,
+			tokenFile: cfg.JWT.TokenFile,
+		}, nil
+	}
+
+	secretID := &auth.SecretID{FromString: cfg.SecretId}
+	opts := []auth.LoginOption{auth.WithMountPath(withDefault(cfg.AppRole.MountPath, "approle"))}
+	if cfg.AppRole.WrappedSecretIdFile != "" {
+		secretID = &auth.SecretID{FromFile: cfg.AppRole.WrappedSecretIdFile}
+		opts = append(opts, auth.WithWrappingToken())
+	}
+	return auth.NewAppRoleAuth(cfg.RoleId, secretID, opts...)
+}
+
+// jwtAuth logs in with a JWT, which is how both the Kubernetes and the JWT/OIDC
+// auth methods work.
+type jwtAuth struct {
+	mountPath string
+	role      string
+	token     string
+	tokenFile string
+}
+
+func (a *jwtAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	jwt := a.token
+	if a.tokenFile != "" {
+		content, err := os.ReadFile(a.tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read JWT file: %w", err)
+		}
+		jwt = strings.TrimSpace(string(content))
+	}
+
+	secret, err := client.Logical().WriteWithContext(ctx, fmt.Sprintf("auth/%s/login", a.mountPath), map[string]interface{}{
+		"role": a.role,
+		"jwt":  jwt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to log in with %s auth: %w", a.mountPath, err)
+	}
+	return secret, nil
+}
+
+// tokenAuth uses a token obtained elsewhere. Its lookup is turned into a login
+// response, so the token is renewed like the ones from other methods.
+type tokenAuth struct {
+	token string
+}
+
+func (a *tokenAuth) Login(ctx context.Context, client *api.Client) (*api.Secret, error) {
+	client.SetToken(a.token)
+
+	lookup, err := client.Auth().Token().LookupSelfWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up token: %w", err)
+	}
+	if lookup == nil {
+		return nil, fmt.Errorf("no token info was returned after lookup")
+	}
+
+	ttl, err := lookup.TokenTTL()
+	if err != nil {
+		return nil, err
+	}
+	renewable, err := lookup.TokenIsRenewable()
+	if err != nil {
+		return nil, err
+	}
+	policies, err := lookup.TokenPolicies()
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.Secret{
+		Auth: &api.SecretAuth{
+			ClientToken:   a.token,
+			Policies:      policies,
+			LeaseDuration: int(ttl.Seconds()),
+			Renewable:     renewable,
+		},
+	}, nil
+}
+
+func withDefault(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
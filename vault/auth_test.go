@@ -0,0 +1,183 @@
+package vault_test
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultConfigValidateAuth(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  vault.VaultConfig
+		env     string
+		wantErr string
+	}{
+		{"approle is the default", vault.VaultConfig{RoleId: "role", SecretId: "secret"}, "", ""},
+		{"approle with a wrapped secret id", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodAppRole, RoleId: "role", AppRole: vault.VaultAppRoleAuthConfig{WrappedSecretIdFile: "/secret"}}, "", ""},
+		{"approle without credentials", vault.VaultConfig{}, "", "approle auth requires role_id, secret_id or approle.wrapped_secret_id_file"},
+		{"kubernetes", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodKubernetes, Kubernetes: vault.VaultKubernetesAuthConfig{Role: "app"}}, "", ""},
+		{"kubernetes without role", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodKubernetes}, "", "kubernetes auth requires kubernetes.role"},
+		{"token from config", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodToken, Token: vault.VaultTokenAuthConfig{Token: "token"}}, "", ""},
+		{"token from environment", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodToken}, "token", ""},
+		{"token missing", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodToken}, "", "token auth requires token.token or VAULT_TOKEN"},
+		{"jwt", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodJWT, JWT: vault.VaultJWTAuthConfig{Role: "job", TokenFile: "/jwt"}}, "", ""},
+		{"jwt without token", vault.VaultConfig{AuthMethod: vault.VaultAuthMethodJWT, JWT: vault.VaultJWTAuthConfig{Role: "job"}}, "", "jwt auth requires jwt.token or jwt.token_file"},
+		{"unknown method", vault.VaultConfig{AuthMethod: "ldap"}, "", `unknown auth method "ldap"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("VAULT_TOKEN", tt.env)
+
+			err := tt.config.ValidateAuth()
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, vault.ErrInvalidAuthConfig)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+
+	t.Run("RegisterVaultConfigValidation should validate the auth method", func(t *testing.T) {
+		validate := validator.New()
+		vault.RegisterVaultConfigValidation(validate)
+
+		config := vault.VaultConfig{AuthMethod: vault.VaultAuthMethodKubernetes, Url: "https://vault", MountPath: "secret", Cert: "cert"}
+		assert.Error(t, validate.Struct(config))
+
+		config.Kubernetes.Role = "app"
+		assert.NoError(t, validate.Struct(config))
+	})
+}
+
+type loginRequest struct {
+	path string
+	body map[string]interface{}
+}
+
+// newAuthServer starts a TLS server answering the login endpoints and returns
+// the path of its certificate.
+func newAuthServer(t *testing.T) (*httptest.Server, string, func() []loginRequest) {
+	var (
+		mu       sync.Mutex
+		requests []loginRequest
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		requests = append(requests, loginRequest{path: r.URL.Path, body: body})
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/sys/wrapping/unwrap":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"secret_id": "unwrapped-" + r.Header.Get("X-Vault-Token")}})
+		case "/v1/auth/token/lookup-self":
+			json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{"ttl": 0, "renewable": false, "policies": []string{"default"}}})
+		default:
+			json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": "token", "renewable": false, "lease_duration": 3600}})
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, writeServerCert(t, server), func() []loginRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]loginRequest{}, requests...)
+	}
+}
+
+func writeServerCert(t *testing.T, server *httptest.Server) string {
+	certFile := filepath.Join(t.TempDir(), "vault.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(certFile, pemData, 0o600))
+	return certFile
+}
+
+func TestVaultRepositoryAuth(t *testing.T) {
+	dir := t.TempDir()
+	jwtFile := filepath.Join(dir, "jwt")
+	assert.NoError(t, os.WriteFile(jwtFile, []byte("service-account-jwt\n"), 0o600))
+	wrappedFile := filepath.Join(dir, "wrapped")
+	assert.NoError(t, os.WriteFile(wrappedFile, []byte("wrapping-token"), 0o600))
+
+	tests := []struct {
+		name  string
+		setup func(cfg *vault.VaultConfig)
+		want  []loginRequest
+	}{
+		{
+			name: "approle",
+			setup: func(cfg *vault.VaultConfig) {
+				cfg.RoleId = "role"
+				cfg.SecretId = "secret"
+			},
+			want: []loginRequest{{path: "/v1/auth/approle/login", body: map[string]interface{}{"role_id": "role", "secret_id": "secret"}}},
+		},
+		{
+			name: "approle with a wrapped secret id",
+			setup: func(cfg *vault.VaultConfig) {
+				cfg.RoleId = "role"
+				cfg.AppRole = vault.VaultAppRoleAuthConfig{WrappedSecretIdFile: wrappedFile, MountPath: "apps"}
+			},
+			want: []loginRequest{
+				{path: "/v1/sys/wrapping/unwrap"},
+				{path: "/v1/auth/apps/login", body: map[string]interface{}{"role_id": "role", "secret_id": "unwrapped-wrapping-token"}},
+			},
+		},
+		{
+			name: "kubernetes",
+			setup: func(cfg *vault.VaultConfig) {
+				cfg.AuthMethod = vault.VaultAuthMethodKubernetes
+				cfg.Kubernetes = vault.VaultKubernetesAuthConfig{Role: "app", TokenFile: jwtFile}
+			},
+			want: []loginRequest{{path: "/v1/auth/kubernetes/login", body: map[string]interface{}{"role": "app", "jwt": "service-account-jwt"}}},
+		},
+		{
+			name: "jwt",
+			setup: func(cfg *vault.VaultConfig) {
+				cfg.AuthMethod = vault.VaultAuthMethodJWT
+				cfg.JWT = vault.VaultJWTAuthConfig{Role: "job", Token: "job-jwt", MountPath: "oidc"}
+			},
+			want: []loginRequest{{path: "/v1/auth/oidc/login", body: map[string]interface{}{"role": "job", "jwt": "job-jwt"}}},
+		},
+		{
+			name: "token",
+			setup: func(cfg *vault.VaultConfig) {
+				cfg.AuthMethod = vault.VaultAuthMethodToken
+				t.Setenv("VAULT_TOKEN", "local-token")
+			},
+			want: []loginRequest{{path: "/v1/auth/token/lookup-self", body: map[string]interface{}{}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, certFile, requests := newAuthServer(t)
+			cfg := &vault.VaultConfig{Url: server.URL, MountPath: "secret", Cert: certFile}
+			tt.setup(cfg)
+
+			repo, err := vault.NewVaultRepository(cfg)
+			assert.NoError(t, err)
+			defer repo.Close()
+			assert.Equal(t, tt.want, requests())
+		})
+	}
+
+	t.Run("invalid auth config should fail before connecting", func(t *testing.T) {
+		_, err := vault.NewVaultRepository(&vault.VaultConfig{AuthMethod: vault.VaultAuthMethodKubernetes})
+		assert.ErrorIs(t, err, vault.ErrInvalidAuthConfig)
+	})
+}
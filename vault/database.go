@@ -0,0 +1,362 @@
+package vault
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const defaultDatabaseMountPath = "database"
+
+var ErrInvalidDatabaseCredentials = errors.New("invalid database credentials")
+
+type DatabaseCredentialsConfig struct {
+	// MountPath of the database secrets engine, defaults to "database"
+	MountPath string `koanf:"mount_path"`
+	Role      string `koanf:"role" validate:"required"`
+	// Backoff between failed requests for new credentials.
+	Backoff VaultBackoffConfig `koanf:"backoff"`
+}
+
+type DatabaseCredentials struct {
+	Username      string
+	Password      string
+	LeaseID       string
+	LeaseDuration time.Duration
+	Renewable     bool
+	// ExpiresAt is when the lease expires, moved forward by every renewal.
+	ExpiresAt time.Time
+}
+
+// DatabaseCredentialsSource gives the credentials new database connections
+// must be opened with. A different LeaseID means the credentials were
+// replaced and connections opened with the previous ones must be retired.
+type DatabaseCredentialsSource interface {
+	Credentials() DatabaseCredentials
+}
+
+// DatabaseCredentialsManager requests dynamic credentials for a role of the
+// database secrets engine, renews their lease and requests new ones before the
+// lease expires for good.
+type DatabaseCredentialsManager struct {
+	client *api.Client
+	config DatabaseCredentialsConfig
+
+	mu      sync.RWMutex
+	current DatabaseCredentials
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+var _ DatabaseCredentialsSource = (*DatabaseCredentialsManager)(nil)
+
+// NewDatabaseCredentialsManager requests the first credentials before
+// returning, so an error means the database cannot be connected to at all.
+// The client is usually the one of the repository, see ClientProvider.
+func NewDatabaseCredentialsManager(ctx context.Context, client *api.Client, config DatabaseCredentialsConfig) (*DatabaseCredentialsManager, error) {
+	if config.Role == "" {
+		return nil, fmt.Errorf("%w: role is required", ErrInvalidDatabaseCredentials)
+	}
+
+	m := &DatabaseCredentialsManager{
+		client: client,
+		config: config,
+		done:   make(chan struct{}),
+	}
+
+	secret, creds, err := m.request(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.current = creds
+
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	go m.manageLease(secret)
+
+	return m, nil
+}
+
+func (m *DatabaseCredentialsManager) Credentials() DatabaseCredentials {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// Close stops renewing the lease and waits for it. The credentials stay valid
+// until the lease expires.
+func (m *DatabaseCredentialsManager) Close() error {
+	m.cancel()
+	<-m.done
+	return nil
+}
+
+func (m *DatabaseCredentialsManager) path() string {
+	return fmt.Sprintf("%s/creds/%s", withDefault(m.config.MountPath, defaultDatabaseMountPath), m.config.Role)
+}
+
+func (m *DatabaseCredentialsManager) newSpan(ctx context.Context) (context.Context, trace.Span) {
+	ctx, span := utils.Tracer.NewSpan(ctx, "vault", "Vault Database Credentials")
+	utils.Tracer.AddSpanTags(span, map[string]string{"vault.database_role": m.config.Role})
+	return ctx, span
+}
+
+func (m *DatabaseCredentialsManager) request(ctx context.Context) (*api.Secret, DatabaseCredentials, error) {
+	ctx, span := m.newSpan(ctx)
+	defer span.End()
+
+	secret, err := m.client.Logical().ReadWithContext(ctx, m.path())
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to request database credentials")
+		return nil, DatabaseCredentials{}, fmt.Errorf("unable to request database credentials for role %s: %w", m.config.Role, err)
+	}
+	if secret == nil || secret.Data == nil {
+		err := fmt.Errorf("%w: no credentials returned for role %s", ErrInvalidDatabaseCredentials, m.config.Role)
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to request database credentials")
+		return nil, DatabaseCredentials{}, err
+	}
+
+	username, _ := secret.Data["username"].(string)
+	password, _ := secret.Data["password"].(string)
+	if username == "" {
+		err := fmt.Errorf("%w: no username returned for role %s", ErrInvalidDatabaseCredentials, m.config.Role)
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to request database credentials")
+		return nil, DatabaseCredentials{}, err
+	}
+
+	leaseDuration := time.Duration(secret.LeaseDuration) * time.Second
+	return secret, DatabaseCredentials{
+		Username:      username,
+		Password:      password,
+		LeaseID:       secret.LeaseID,
+		LeaseDuration: leaseDuration,
+		Renewable:     secret.Renewable,
+		ExpiresAt:     time.Now().Add(leaseDuration),
+	}, nil
+}
+
+// manageLease keeps the lease of secret renewed and replaces the credentials
+// once it cannot be renewed anymore, until the manager is closed.
+func (m *DatabaseCredentialsManager) manageLease(secret *api.Secret) {
+	defer close(m.done)
+
+	for {
+		m.watchLease(secret)
+		if m.ctx.Err() != nil {
+			return
+		}
+
+		failures := 0
+		for {
+			var creds DatabaseCredentials
+			var err error
+			secret, creds, err = m.request(m.ctx)
+			if err == nil {
+				m.mu.Lock()
+				m.current = creds
+				m.mu.Unlock()
+				break
+			}
+			if m.ctx.Err() != nil {
+				return
+			}
+
+			failures++
+			if !sleep(m.ctx, m.config.Backoff.delay(failures)) {
+				return
+			}
+		}
+	}
+}
+
+// watchLease returns when the lease of secret is about to expire and cannot
+// be renewed anymore, or when the manager is closed.
+func (m *DatabaseCredentialsManager) watchLease(secret *api.Secret) {
+	// Leases without a TTL never expire.
+	if secret.LeaseDuration <= 0 {
+		<-m.ctx.Done()
+		return
+	}
+
+	watcher, err := m.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{Secret: secret})
+	if err != nil {
+		_, span := m.newSpan(m.ctx)
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to watch database credentials lease")
+		span.End()
+
+		sleep(m.ctx, time.Duration(secret.LeaseDuration)*time.Second*2/3)
+		return
+	}
+
+	go watcher.Start()
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+
+		case err := <-watcher.DoneCh():
+			_, span := m.newSpan(m.ctx)
+			if err != nil {
+				utils.Tracer.AddSpanEvents(span, "Failed to renew lease", map[string]string{"message": fmt.Sprintf("Failed to renew lease: %v. Requesting new credentials.", err)})
+			} else {
+				utils.Tracer.AddSpanEvents(span, "Lease expiring", map[string]string{"message": "Lease can no longer be renewed. Requesting new credentials."})
+			}
+			span.End()
+			return
+
+		case renewal := <-watcher.RenewCh():
+			leaseDuration := time.Duration(renewal.Secret.LeaseDuration) * time.Second
+			m.mu.Lock()
+			if m.current.LeaseID == secret.LeaseID {
+				m.current.LeaseDuration = leaseDuration
+				m.current.ExpiresAt = renewal.RenewedAt.Add(leaseDuration)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+// DatabaseConnector opens database connections with the current credentials
+// of a DatabaseCredentialsSource, to be used with sql.OpenDB. Connections
+// opened with replaced credentials are closed by the pool as soon as they are
+// returned to it, so queries in flight finish with the previous credentials.
+// Setting SetConnMaxLifetime below the lease TTL also retires idle
+// connections that are not reused before the previous credentials expire.
+type DatabaseConnector struct {
+	source DatabaseCredentialsSource
+	driver driver.Driver
+	dsn    func(creds DatabaseCredentials) string
+}
+
+var _ driver.Connector = (*DatabaseConnector)(nil)
+
+// NewDatabaseConnector opens connections with drv, e.g. &pq.Driver{}, to the
+// data source name dsn builds from the credentials.
+func NewDatabaseConnector(source DatabaseCredentialsSource, drv driver.Driver, dsn func(creds DatabaseCredentials) string) *DatabaseConnector {
+	return &DatabaseConnector{source: source, driver: drv, dsn: dsn}
+}
+
+func (c *DatabaseConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	creds := c.source.Credentials()
+	name := c.dsn(creds)
+
+	var conn driver.Conn
+	var err error
+	if driverCtx, ok := c.driver.(driver.DriverContext); ok {
+		var connector driver.Connector
+		connector, err = driverCtx.OpenConnector(name)
+		if err != nil {
+			return nil, err
+		}
+		conn, err = connector.Connect(ctx)
+	} else {
+		conn, err = c.driver.Open(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentialsConn{Conn: conn, source: c.source, leaseID: creds.LeaseID}, nil
+}
+
+func (c *DatabaseConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// credentialsConn forwards to the driver connection and reports itself
+// invalid once the credentials it was opened with are replaced.
+type credentialsConn struct {
+	driver.Conn
+	source  DatabaseCredentialsSource
+	leaseID string
+}
+
+var (
+	_ driver.Validator          = (*credentialsConn)(nil)
+	_ driver.SessionResetter    = (*credentialsConn)(nil)
+	_ driver.Pinger             = (*credentialsConn)(nil)
+	_ driver.ExecerContext      = (*credentialsConn)(nil)
+	_ driver.QueryerContext     = (*credentialsConn)(nil)
+	_ driver.ConnPrepareContext = (*credentialsConn)(nil)
+	_ driver.ConnBeginTx        = (*credentialsConn)(nil)
+	_ driver.NamedValueChecker  = (*credentialsConn)(nil)
+)
+
+func (c *credentialsConn) stale() bool {
+	return c.source.Credentials().LeaseID != c.leaseID
+}
+
+func (c *credentialsConn) IsValid() bool {
+	if c.stale() {
+		return false
+	}
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *credentialsConn) ResetSession(ctx context.Context) error {
+	if c.stale() {
+		return driver.ErrBadConn
+	}
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *credentialsConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *credentialsConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *credentialsConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *credentialsConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *credentialsConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	if opts.Isolation != driver.IsolationLevel(0) || opts.ReadOnly {
+		return nil, errors.New("driver does not support non-default isolation level or read-only transactions")
+	}
+	return c.Conn.Begin()
+}
+
+func (c *credentialsConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
@@ -0,0 +1,230 @@
+package vault_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func newDatabaseCredsClient(t *testing.T, leaseDuration int, failing *int32) (*api.Client, *int32) {
+	var issued int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/database/creds/app" || atomic.LoadInt32(failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"database is down"}})
+			return
+		}
+		n := atomic.AddInt32(&issued, 1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       fmt.Sprintf("database/creds/app/%d", n),
+			"lease_duration": leaseDuration,
+			"renewable":      false,
+			"data":           map[string]interface{}{"username": fmt.Sprintf("v-app-%d", n), "password": "password"},
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL, HttpClient: server.Client()})
+	assert.NoError(t, err)
+	return client, &issued
+}
+
+func TestDatabaseCredentialsManager(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("should request credentials for the role", func(t *testing.T) {
+		var failing int32
+		client, _ := newDatabaseCredsClient(t, 3600, &failing)
+
+		manager, err := vault.NewDatabaseCredentialsManager(ctx, client, vault.DatabaseCredentialsConfig{Role: "app"})
+		assert.NoError(t, err)
+		defer manager.Close()
+
+		creds := manager.Credentials()
+		assert.Equal(t, "v-app-1", creds.Username)
+		assert.Equal(t, "password", creds.Password)
+		assert.Equal(t, "database/creds/app/1", creds.LeaseID)
+		assert.Equal(t, time.Hour, creds.LeaseDuration)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), creds.ExpiresAt, time.Minute)
+	})
+
+	t.Run("should fail when no credentials can be requested", func(t *testing.T) {
+		failing := int32(1)
+		client, _ := newDatabaseCredsClient(t, 3600, &failing)
+
+		_, err := vault.NewDatabaseCredentialsManager(ctx, client, vault.DatabaseCredentialsConfig{Role: "app"})
+		assert.ErrorContains(t, err, "database is down")
+
+		_, err = vault.NewDatabaseCredentialsManager(ctx, client, vault.DatabaseCredentialsConfig{})
+		assert.ErrorIs(t, err, vault.ErrInvalidDatabaseCredentials)
+	})
+
+	t.Run("should replace credentials before their lease expires", func(t *testing.T) {
+		var failing int32
+		client, issued := newDatabaseCredsClient(t, 2, &failing)
+
+		manager, err := vault.NewDatabaseCredentialsManager(ctx, client, vault.DatabaseCredentialsConfig{Role: "app"})
+		assert.NoError(t, err)
+		defer manager.Close()
+
+		first := manager.Credentials()
+		assert.Eventually(t, func() bool {
+			return manager.Credentials().Username == "v-app-2"
+		}, 3*time.Second, 10*time.Millisecond)
+		assert.True(t, time.Now().Before(first.ExpiresAt))
+		assert.Equal(t, int32(2), atomic.LoadInt32(issued))
+	})
+
+	t.Run("Close should stop replacing credentials", func(t *testing.T) {
+		var failing int32
+		client, issued := newDatabaseCredsClient(t, 1, &failing)
+
+		manager, err := vault.NewDatabaseCredentialsManager(ctx, client, vault.DatabaseCredentialsConfig{Role: "app"})
+		assert.NoError(t, err)
+
+		// Close waits for the lease watcher to exit, so nothing can request
+		// credentials once it returns.
+		stopped := make(chan struct{})
+		go func() {
+			assert.NoError(t, manager.Close())
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(time.Second):
+			t.Fatal("Close did not stop the lease watcher")
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(issued))
+		assert.Equal(t, "v-app-1", manager.Credentials().Username)
+	})
+}
+
+type fakeCredentialsSource struct {
+	mu    sync.Mutex
+	creds vault.DatabaseCredentials
+}
+
+func (s *fakeCredentialsSource) Credentials() vault.DatabaseCredentials {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.creds
+}
+
+func (s *fakeCredentialsSource) Rotate(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.creds = vault.DatabaseCredentials{Username: username, Password: "password", LeaseID: "lease/" + username}
+}
+
+type fakeDriver struct {
+	mu    sync.Mutex
+	conns []*fakeConn
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	conn := &fakeConn{name: name}
+	d.conns = append(d.conns, conn)
+	return conn, nil
+}
+
+func (d *fakeDriver) Opened() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for _, conn := range d.conns {
+		names = append(names, conn.name)
+	}
+	return names
+}
+
+type fakeConn struct {
+	name   string
+	closed int32
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *fakeConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, driver.ErrSkip
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) Closed() bool {
+	return atomic.LoadInt32(&c.closed) == 1
+}
+
+func TestDatabaseConnector(t *testing.T) {
+	ctx := context.Background()
+	dsn := func(creds vault.DatabaseCredentials) string {
+		return creds.Username + ":" + creds.Password
+	}
+
+	t.Run("connections in use should be closed once returned after a rotation", func(t *testing.T) {
+		source := &fakeCredentialsSource{}
+		source.Rotate("v-app-1")
+		drv := &fakeDriver{}
+		db := sql.OpenDB(vault.NewDatabaseConnector(source, drv, dsn))
+		defer db.Close()
+
+		conn, err := db.Conn(ctx)
+		assert.NoError(t, err)
+		_, err = conn.ExecContext(ctx, "SELECT 1")
+		assert.NoError(t, err)
+
+		source.Rotate("v-app-2")
+		_, err = conn.ExecContext(ctx, "SELECT 1")
+		assert.NoError(t, err)
+		assert.False(t, drv.conns[0].Closed())
+
+		assert.NoError(t, conn.Close())
+		assert.True(t, drv.conns[0].Closed())
+
+		_, err = db.ExecContext(ctx, "SELECT 1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"v-app-1:password", "v-app-2:password"}, drv.Opened())
+	})
+
+	t.Run("idle connections should be replaced after a rotation", func(t *testing.T) {
+		source := &fakeCredentialsSource{}
+		source.Rotate("v-app-1")
+		drv := &fakeDriver{}
+		db := sql.OpenDB(vault.NewDatabaseConnector(source, drv, dsn))
+		defer db.Close()
+
+		_, err := db.ExecContext(ctx, "SELECT 1")
+		assert.NoError(t, err)
+		_, err = db.ExecContext(ctx, "SELECT 1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"v-app-1:password"}, drv.Opened())
+
+		source.Rotate("v-app-2")
+		_, err = db.ExecContext(ctx, "SELECT 1")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"v-app-1:password", "v-app-2:password"}, drv.Opened())
+		assert.True(t, drv.conns[0].Closed())
+	})
+}
@@ -0,0 +1,66 @@
+package vault
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSecretNotFound         = errors.New("secret not found")
+	ErrSecretVersionDeleted   = errors.New("secret version was deleted")
+	ErrSecretVersionDestroyed = errors.New("secret version was destroyed")
+	ErrCheckAndSetMismatch    = errors.New("check-and-set version does not match the current version")
+)
+
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	checkAndSet *int
+}
+
+// WithCheckAndSet only writes if the current version of the client secrets is
+// version, failing with ErrCheckAndSetMismatch otherwise. Version 0 only
+// writes if the client has no secrets yet.
+func WithCheckAndSet(version int) WriteOption {
+	return func(o *writeOptions) {
+		o.checkAndSet = &version
+	}
+}
+
+func newWriteOptions(opts []WriteOption) writeOptions {
+	options := writeOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// SecretVersion describes a version of a client's secrets in the KV v2 engine.
+// DeletionTime is zero unless the version was deleted.
+type SecretVersion struct {
+	Version      int
+	CreatedTime  time.Time
+	DeletionTime time.Time
+	Destroyed    bool
+}
+
+type SecretMetadata struct {
+	CreatedTime    time.Time
+	UpdatedTime    time.Time
+	CurrentVersion int
+	OldestVersion  int
+	CustomMetadata map[string]string
+	Versions       map[int]SecretVersion
+}
+
+// checkSecretVersion returns a typed error when the version data is not
+// available anymore.
+func checkSecretVersion(version SecretVersion) error {
+	if version.Destroyed {
+		return ErrSecretVersionDestroyed
+	}
+	if !version.DeletionTime.IsZero() {
+		return ErrSecretVersionDeleted
+	}
+	return nil
+}
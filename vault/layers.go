@@ -0,0 +1,133 @@
+package vault
+
+import (
+	"context"
+	"errors"
+
+	utils "github.com/criticalmassbr/ms-utils"
+)
+
+// SecretSource is the layer a secret of a client was read from.
+type SecretSource string
+
+const (
+	SecretSourceDefaults    SecretSource = "defaults"
+	SecretSourceEnvironment SecretSource = "environment"
+	SecretSourceClient      SecretSource = "client"
+)
+
+// VaultLayersConfig sets the paths merged under the secrets of every client,
+// so values shared by most tenants are stored once. Keys of the environment
+// path override the defaults, and keys of the client override both. Either
+// path may be empty or missing in Vault.
+type VaultLayersConfig struct {
+	DefaultsPath    string `koanf:"defaults_path"`
+	EnvironmentPath string `koanf:"environment_path"`
+}
+
+// WithSecretLayers merges the layers into the secrets returned by GetSecret,
+// GetSecrets and ReadSecrets. Each layer is cached under its own path and
+// read once for every client. Invalidating a layer path invalidates every
+// client, so a SecretsWatcher listing it reloads them all when it changes.
+func WithSecretLayers(config VaultLayersConfig) VaultServiceOption {
+	return func(s *VaultService) {
+		s.layers = config
+	}
+}
+
+// clientSecrets are the merged secrets of a client with the source of each
+// key.
+type clientSecrets struct {
+	values  map[string]interface{}
+	sources map[string]SecretSource
+}
+
+type secretLayer struct {
+	path   string
+	source SecretSource
+}
+
+func (s *VaultService) secretLayers() []secretLayer {
+	var layers []secretLayer
+	if s.layers.DefaultsPath != "" {
+		layers = append(layers, secretLayer{path: s.layers.DefaultsPath, source: SecretSourceDefaults})
+	}
+	if s.layers.EnvironmentPath != "" {
+		layers = append(layers, secretLayer{path: s.layers.EnvironmentPath, source: SecretSourceEnvironment})
+	}
+	return layers
+}
+
+func (s *VaultService) secretLayer(path string) (secretLayer, bool) {
+	for _, layer := range s.secretLayers() {
+		if layer.path == path {
+			return layer, true
+		}
+	}
+	return secretLayer{}, false
+}
+
+// loadLayer reads the secrets of the layer, which are empty if it is missing.
+func (s *VaultService) loadLayer(ctx context.Context, layer secretLayer) (*clientSecrets, error) {
+	secrets, err := s.repo.GetSecretsWithContext(ctx, layer.path)
+	if errors.Is(err, ErrSecretNotFound) {
+		secrets, err = map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	loaded := &clientSecrets{values: map[string]interface{}{}, sources: map[string]SecretSource{}}
+	loaded.set(secrets, layer.source)
+	return loaded, nil
+}
+
+// mergeLayers merges the secrets of the client on top of the cached layers.
+// The client secrets must exist, while missing layers are empty.
+func (s *VaultService) mergeLayers(ctx context.Context, clientSlug string) (*clientSecrets, error) {
+	secrets, err := s.repo.GetSecretsWithContext(ctx, clientSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := s.secretLayers()
+	if len(layers) == 0 {
+		merged := &clientSecrets{values: secrets, sources: make(map[string]SecretSource, len(secrets))}
+		for key := range secrets {
+			merged.sources[key] = SecretSourceClient
+		}
+		return merged, nil
+	}
+
+	merged := &clientSecrets{values: map[string]interface{}{}, sources: map[string]SecretSource{}}
+	for _, layer := range layers {
+		layerSecrets, err := s.cache.GetOrLoad(ctx, layer.path, s.loadClientSecrets)
+		if err != nil {
+			return nil, err
+		}
+		merged.set(layerSecrets.values, layer.source)
+	}
+	merged.set(secrets, SecretSourceClient)
+	return merged, nil
+}
+
+func (c *clientSecrets) set(secrets map[string]interface{}, source SecretSource) {
+	for key, value := range secrets {
+		c.values[key] = value
+		c.sources[key] = source
+	}
+}
+
+// GetSecretSource returns the layer the key of the client is read from, or an
+// empty source if the key is not set in any layer.
+func (s *VaultService) GetSecretSource(ctx context.Context, clientSlug string, key VaultSecretKey) (SecretSource, error) {
+	ctx, span := newSpan(ctx, "Vault Get Secret Source", clientSlug)
+	defer span.End()
+
+	secrets, err := s.getCachedSecrets(ctx, clientSlug)
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to get secret source")
+		return "", err
+	}
+	return secrets.sources[string(key)], nil
+}
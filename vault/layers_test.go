@@ -0,0 +1,121 @@
+package vault_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecretLayers(t *testing.T) {
+	ctx := context.Background()
+	vaultMockData := vault.VaultMockData{
+		"_defaults": {
+			"SMTP_HOST": "smtp.example.com",
+			"SMTP_PORT": "25",
+			"NAME":      "default",
+		},
+		"_defaults/production": {
+			"SMTP_PORT": "587",
+		},
+		"client1": {
+			"NAME": "client 1",
+		},
+	}
+	layers := vault.VaultLayersConfig{DefaultsPath: "_defaults", EnvironmentPath: "_defaults/production"}
+
+	t.Run("GetSecret and GetSecrets should merge the layers in order", func(t *testing.T) {
+		service := vault.NewVaultService(vault.NewMockVaultRepository(vaultMockData), vault.WithSecretLayers(layers))
+
+		value, err := service.GetSecret("client1", "SMTP_HOST")
+		assert.NoError(t, err)
+		assert.Equal(t, "smtp.example.com", value)
+
+		secrets, err := service.GetSecrets("client1", []vault.VaultSecretKey{"SMTP_PORT", "NAME"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"SMTP_PORT": "587", "NAME": "client 1"}, secrets)
+	})
+
+	t.Run("ReadSecrets should read the merged secrets", func(t *testing.T) {
+		type Secrets struct {
+			Name     string `koanf:"NAME"`
+			SMTPHost string `koanf:"SMTP_HOST" validate:"required"`
+			SMTPPort int    `koanf:"SMTP_PORT"`
+		}
+
+		service := vault.NewVaultService(vault.NewMockVaultRepository(vaultMockData), vault.WithSecretLayers(layers))
+		secrets, err := vault.ReadSecrets[Secrets](ctx, service, "client1")
+		assert.NoError(t, err)
+		assert.Equal(t, Secrets{Name: "client 1", SMTPHost: "smtp.example.com", SMTPPort: 587}, secrets)
+	})
+
+	t.Run("GetSecretSource should tell where each value comes from", func(t *testing.T) {
+		service := vault.NewVaultService(vault.NewMockVaultRepository(vaultMockData), vault.WithSecretLayers(layers))
+
+		for key, expected := range map[vault.VaultSecretKey]vault.SecretSource{
+			"SMTP_HOST": vault.SecretSourceDefaults,
+			"SMTP_PORT": vault.SecretSourceEnvironment,
+			"NAME":      vault.SecretSourceClient,
+			"MISSING":   "",
+		} {
+			source, err := service.GetSecretSource(ctx, "client1", key)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, source, key)
+		}
+
+		service = vault.NewVaultService(vault.NewMockVaultRepository(vaultMockData))
+		source, err := service.GetSecretSource(ctx, "client1", "NAME")
+		assert.NoError(t, err)
+		assert.Equal(t, vault.SecretSourceClient, source)
+	})
+
+	t.Run("layers should be read once for every client", func(t *testing.T) {
+		data := vault.VaultMockData{"_defaults": vaultMockData["_defaults"]}
+		for _, clientSlug := range []string{"client1", "client2", "client3"} {
+			data[clientSlug] = map[string]interface{}{"NAME": clientSlug}
+		}
+		repoMock := vault.NewMockVaultRepository(data)
+		service := vault.NewVaultService(repoMock, vault.WithSecretLayers(layers))
+
+		for _, clientSlug := range []string{"client1", "client2", "client3"} {
+			value, err := service.GetSecret(clientSlug, "SMTP_HOST")
+			assert.NoError(t, err)
+			assert.Equal(t, "smtp.example.com", value)
+		}
+		assert.Equal(t, 1, repoMock.NumberOfCalls("_defaults"))
+		assert.Equal(t, 1, repoMock.NumberOfCalls("_defaults/production"))
+	})
+
+	t.Run("missing layers should be skipped but not missing clients", func(t *testing.T) {
+		service := vault.NewVaultService(vault.NewMockVaultRepository(vaultMockData), vault.WithSecretLayers(vault.VaultLayersConfig{
+			DefaultsPath:    "_defaults",
+			EnvironmentPath: "_defaults/staging",
+		}))
+
+		value, err := service.GetSecret("client1", "SMTP_PORT")
+		assert.NoError(t, err)
+		assert.Equal(t, "25", value)
+
+		_, err = service.GetSecret("client2", "SMTP_PORT")
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+	})
+
+	t.Run("invalidating a layer should reload every client", func(t *testing.T) {
+		repoMock := vault.NewMockVaultRepository(vaultMockData)
+		service := vault.NewVaultService(repoMock, vault.WithSecretLayers(layers))
+
+		service.GetSecret("client1", "NAME")
+		service.Invalidate("client1")
+		service.GetSecret("client1", "NAME")
+		assert.Equal(t, 2, repoMock.NumberOfCalls("client1"))
+		assert.Equal(t, 1, repoMock.NumberOfCalls("_defaults"))
+
+		_, err := service.PatchSecrets(ctx, "_defaults", map[string]interface{}{"SMTP_HOST": "relay.example.com"})
+		assert.NoError(t, err)
+		value, err := service.GetSecret("client1", "SMTP_HOST")
+		assert.NoError(t, err)
+		assert.Equal(t, "relay.example.com", value)
+		assert.Equal(t, 3, repoMock.NumberOfCalls("client1"))
+	})
+}
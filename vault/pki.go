@@ -0,0 +1,272 @@
+package vault
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultPKIMountPath = "pki"
+
+var ErrInvalidCertificate = errors.New("invalid certificate")
+
+type PKIConfig struct {
+	// MountPath of the PKI secrets engine, defaults to "pki"
+	MountPath  string   `koanf:"mount_path"`
+	Role       string   `koanf:"role" validate:"required"`
+	CommonName string   `koanf:"common_name" validate:"required"`
+	AltNames   []string `koanf:"alt_names"`
+	IPSANs     []string `koanf:"ip_sans"`
+	// TTL defaults to the TTL of the role
+	TTL time.Duration `koanf:"ttl"`
+	// RotateBefore is how long before expiring a certificate is replaced,
+	// defaults to a third of its validity. It must be shorter than TTL, and a
+	// certificate is never replaced before a quarter of its validity has
+	// passed, so a role issuing shorter certificates cannot flood Vault.
+	RotateBefore time.Duration `koanf:"rotate_before"`
+	// Backoff between failed requests for new certificates.
+	Backoff VaultBackoffConfig `koanf:"backoff"`
+}
+
+// CertificateManager issues a certificate from a role of the PKI secrets
+// engine, keeps it in memory only and issues a new one before it expires.
+type CertificateManager struct {
+	client *api.Client
+	config PKIConfig
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	caPool      *x509.CertPool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCertificateManager issues the first certificate before returning. The
+// client is usually the one of the repository, see ClientProvider.
+func NewCertificateManager(ctx context.Context, client *api.Client, config PKIConfig) (*CertificateManager, error) {
+	if config.Role == "" || config.CommonName == "" {
+		return nil, fmt.Errorf("%w: role and common name are required", ErrInvalidCertificate)
+	}
+	if config.TTL > 0 && config.RotateBefore >= config.TTL {
+		return nil, fmt.Errorf("%w: rotate before %s must be shorter than the TTL %s", ErrInvalidCertificate, config.RotateBefore, config.TTL)
+	}
+
+	m := &CertificateManager{
+		client: client,
+		config: config,
+		done:   make(chan struct{}),
+	}
+
+	certificate, caPool, err := m.issue(ctx)
+	if err != nil {
+		return nil, err
+	}
+	m.certificate, m.caPool = certificate, caPool
+
+	m.ctx, m.cancel = context.WithCancel(context.Background())
+	go m.rotate()
+
+	return m, nil
+}
+
+// Certificate returns the current certificate, with its parsed Leaf.
+func (m *CertificateManager) Certificate() *tls.Certificate {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.certificate
+}
+
+// CAPool contains the CA chain that issued the current certificate.
+func (m *CertificateManager) CAPool() *x509.CertPool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.caPool
+}
+
+// TLSConfig returns a config for mutual TLS between services whose
+// certificates are issued by the same CA. The current certificate and CA pool
+// are picked on every handshake, for both servers and clients, so the config
+// follows rotations of the CA too. Clients must set ServerName, which
+// http.Transport does from the URL, to verify the host of the server.
+func (m *CertificateManager) TLSConfig() *tls.Config {
+	config := m.serverConfig()
+	// Clients verify the server against the current pool in
+	// VerifyConnection instead of a fixed RootCAs.
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = m.verifyServer
+	config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return m.Certificate(), nil
+	}
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return m.serverConfig(), nil
+	}
+	return config
+}
+
+func (m *CertificateManager) serverConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return m.Certificate(), nil
+		},
+		ClientCAs:  m.CAPool(),
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}
+}
+
+// verifyServer does the verification skipped by InsecureSkipVerify with the
+// current CA pool.
+func (m *CertificateManager) verifyServer(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return fmt.Errorf("%w: no server certificate", ErrInvalidCertificate)
+	}
+	// An empty DNSName would skip the host check and accept any
+	// certificate of the CA.
+	if state.ServerName == "" {
+		return fmt.Errorf("%w: ServerName is required to verify the server", ErrInvalidCertificate)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, certificate := range state.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Roots:         m.CAPool(),
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// Close stops rotating the certificate and waits for it. The current
+// certificate is still returned until it expires.
+func (m *CertificateManager) Close() error {
+	m.cancel()
+	<-m.done
+	return nil
+}
+
+func (m *CertificateManager) issue(ctx context.Context) (*tls.Certificate, *x509.CertPool, error) {
+	ctx, span := utils.Tracer.NewSpan(ctx, "vault", "Vault PKI Issue Certificate")
+	utils.Tracer.AddSpanTags(span, map[string]string{"vault.pki_role": m.config.Role, "vault.pki_common_name": m.config.CommonName})
+	defer span.End()
+
+	data := map[string]interface{}{"common_name": m.config.CommonName}
+	if len(m.config.AltNames) > 0 {
+		data["alt_names"] = strings.Join(m.config.AltNames, ",")
+	}
+	if len(m.config.IPSANs) > 0 {
+		data["ip_sans"] = strings.Join(m.config.IPSANs, ",")
+	}
+	if m.config.TTL > 0 {
+		data["ttl"] = fmt.Sprintf("%ds", int(m.config.TTL.Seconds()))
+	}
+
+	path := fmt.Sprintf("%s/issue/%s", withDefault(m.config.MountPath, defaultPKIMountPath), m.config.Role)
+	secret, err := m.client.Logical().WriteWithContext(ctx, path, data)
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to issue certificate")
+		return nil, nil, fmt.Errorf("unable to issue certificate for role %s: %w", m.config.Role, err)
+	}
+
+	certificate, caPool, err := parseIssuedCertificate(secret)
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to issue certificate")
+		return nil, nil, err
+	}
+	utils.Tracer.AddSpanTags(span, map[string]string{"vault.pki_serial_number": fmt.Sprintf("%x", certificate.Leaf.SerialNumber)})
+	return certificate, caPool, nil
+}
+
+func parseIssuedCertificate(secret *api.Secret) (*tls.Certificate, *x509.CertPool, error) {
+	if secret == nil || secret.Data == nil {
+		return nil, nil, fmt.Errorf("%w: empty response", ErrInvalidCertificate)
+	}
+
+	certPEM, _ := secret.Data["certificate"].(string)
+	keyPEM, _ := secret.Data["private_key"].(string)
+	issuingCA, _ := secret.Data["issuing_ca"].(string)
+
+	chain := []string{issuingCA}
+	if caChain, ok := secret.Data["ca_chain"].([]interface{}); ok && len(caChain) > 0 {
+		chain = chain[:0]
+		for _, ca := range caChain {
+			if pem, ok := ca.(string); ok {
+				chain = append(chain, pem)
+			}
+		}
+	}
+
+	certificate, err := tls.X509KeyPair([]byte(certPEM+"\n"+strings.Join(chain, "\n")), []byte(keyPEM))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+	certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidCertificate, err)
+	}
+
+	caPool := x509.NewCertPool()
+	for _, ca := range chain {
+		caPool.AppendCertsFromPEM([]byte(ca))
+	}
+	return &certificate, caPool, nil
+}
+
+// rotateDelay returns how long until the certificate must be replaced, at
+// least a quarter of its validity. Vault backdates certificates, so the
+// validity may be longer than the TTL.
+func (m *CertificateManager) rotateDelay(certificate *tls.Certificate) time.Duration {
+	validity := certificate.Leaf.NotAfter.Sub(certificate.Leaf.NotBefore)
+	before := m.config.RotateBefore
+	if before <= 0 {
+		before = validity / 3
+	}
+
+	delay := time.Until(certificate.Leaf.NotAfter.Add(-before))
+	if minimum := validity / 4; delay < minimum {
+		delay = minimum
+	}
+	return delay
+}
+
+// rotate issues a new certificate whenever the current one must be replaced,
+// until the manager is closed.
+func (m *CertificateManager) rotate() {
+	defer close(m.done)
+
+	failures := 0
+	for {
+		delay := m.rotateDelay(m.Certificate())
+		if failures > 0 {
+			delay = m.config.Backoff.delay(failures)
+		}
+		if !sleep(m.ctx, delay) {
+			return
+		}
+
+		certificate, caPool, err := m.issue(m.ctx)
+		if err != nil {
+			if m.ctx.Err() != nil {
+				return
+			}
+			failures++
+			continue
+		}
+
+		failures = 0
+		m.mu.Lock()
+		m.certificate, m.caPool = certificate, caPool
+		m.mu.Unlock()
+	}
+}
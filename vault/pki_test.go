@@ -0,0 +1,298 @@
+package vault_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePKI is a PKI engine issuing certificates valid for ttl from a
+// self-signed CA, which rotateCA replaces.
+type fakePKI struct {
+	t      *testing.T
+	ttl    time.Duration
+	issued int32
+
+	mu     sync.Mutex
+	caKey  *ecdsa.PrivateKey
+	caCert *x509.Certificate
+	caPEM  string
+}
+
+func (p *fakePKI) rotateCA() {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(p.t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoError(p.t, err)
+	caCert, _ := x509.ParseCertificate(caDER)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.caKey, p.caCert = caKey, caCert
+	p.caPEM = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}))
+}
+
+func (p *fakePKI) Issued() int32 {
+	return atomic.LoadInt32(&p.issued)
+}
+
+func (p *fakePKI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		CommonName string `json:"common_name"`
+		AltNames   string `json:"alt_names"`
+	}
+	json.NewDecoder(r.Body).Decode(&request)
+	if r.URL.Path != "/v1/pki/issue/service" || request.CommonName == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"unknown role"}})
+		return
+	}
+
+	p.mu.Lock()
+	caKey, caCert, caPEM := p.caKey, p.caCert, p.caPEM
+	p.mu.Unlock()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(int64(atomic.AddInt32(&p.issued, 1)) + 1),
+		Subject:      pkix.Name{CommonName: request.CommonName},
+		DNSNames:     append([]string{request.CommonName}, strings.Split(request.AltNames, ",")...),
+		NotBefore:    now,
+		NotAfter:     now.Add(p.ttl),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+		"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"private_key": string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		"issuing_ca":  caPEM,
+		"ca_chain":    []string{caPEM},
+	}})
+}
+
+// newPKIClient returns a client of a fakePKI issuing certificates valid for
+// ttl.
+func newPKIClient(t *testing.T, ttl time.Duration) (*api.Client, *fakePKI) {
+	pki := &fakePKI{t: t, ttl: ttl}
+	pki.rotateCA()
+	server := httptest.NewTLSServer(pki)
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL, HttpClient: server.Client()})
+	assert.NoError(t, err)
+	return client, pki
+}
+
+// handshake connects a client and a server with the given configs and returns
+// the serial numbers of the certificates each side presented.
+func handshake(t *testing.T, serverConfig *tls.Config, clientConfig *tls.Config) (int64, int64) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	server := tls.Server(serverConn, serverConfig)
+	errs := make(chan error, 1)
+	go func() { errs <- server.Handshake() }()
+
+	clientConfig = clientConfig.Clone()
+	clientConfig.ServerName = "service.internal"
+	client := tls.Client(clientConn, clientConfig)
+	assert.NoError(t, client.Handshake())
+	assert.NoError(t, <-errs)
+
+	return client.ConnectionState().PeerCertificates[0].SerialNumber.Int64(),
+		server.ConnectionState().PeerCertificates[0].SerialNumber.Int64()
+}
+
+func TestCertificateManager(t *testing.T) {
+	ctx := context.Background()
+	config := vault.PKIConfig{Role: "service", CommonName: "service.internal", AltNames: []string{"service"}}
+
+	t.Run("should issue a certificate for the role", func(t *testing.T) {
+		client, _ := newPKIClient(t, time.Hour)
+
+		manager, err := vault.NewCertificateManager(ctx, client, config)
+		assert.NoError(t, err)
+		defer manager.Close()
+
+		leaf := manager.Certificate().Leaf
+		assert.Equal(t, "service.internal", leaf.Subject.CommonName)
+		assert.Equal(t, []string{"service.internal", "service"}, leaf.DNSNames)
+		assert.Len(t, manager.Certificate().Certificate, 2)
+	})
+
+	t.Run("should fail when no certificate can be issued", func(t *testing.T) {
+		client, _ := newPKIClient(t, time.Hour)
+
+		_, err := vault.NewCertificateManager(ctx, client, vault.PKIConfig{Role: "unknown", CommonName: "service.internal"})
+		assert.ErrorContains(t, err, "unknown role")
+
+		_, err = vault.NewCertificateManager(ctx, client, vault.PKIConfig{Role: "service"})
+		assert.ErrorIs(t, err, vault.ErrInvalidCertificate)
+	})
+
+	t.Run("TLSConfig should be usable for mutual TLS", func(t *testing.T) {
+		client, _ := newPKIClient(t, time.Hour)
+
+		serverManager, err := vault.NewCertificateManager(ctx, client, config)
+		assert.NoError(t, err)
+		defer serverManager.Close()
+		clientManager, err := vault.NewCertificateManager(ctx, client, config)
+		assert.NoError(t, err)
+		defer clientManager.Close()
+
+		serverSerial, clientSerial := handshake(t, serverManager.TLSConfig(), clientManager.TLSConfig())
+		assert.Equal(t, serverManager.Certificate().Leaf.SerialNumber.Int64(), serverSerial)
+		assert.Equal(t, clientManager.Certificate().Leaf.SerialNumber.Int64(), clientSerial)
+	})
+
+	t.Run("certificates should be rotated before they expire", func(t *testing.T) {
+		client, pki := newPKIClient(t, 4*time.Second)
+
+		manager, err := vault.NewCertificateManager(ctx, client, vault.PKIConfig{
+			Role:         "service",
+			CommonName:   "service.internal",
+			RotateBefore: 2 * time.Second,
+		})
+		assert.NoError(t, err)
+		defer manager.Close()
+
+		tlsConfig := manager.TLSConfig()
+		first := manager.Certificate()
+		assert.Eventually(t, func() bool {
+			return manager.Certificate() != first
+		}, 4*time.Second, 10*time.Millisecond)
+		assert.True(t, time.Now().Before(first.Leaf.NotAfter))
+		assert.GreaterOrEqual(t, pki.Issued(), int32(2))
+
+		serverSerial, clientSerial := handshake(t, tlsConfig, tlsConfig)
+		assert.Equal(t, manager.Certificate().Leaf.SerialNumber.Int64(), serverSerial)
+		assert.Equal(t, serverSerial, clientSerial)
+	})
+
+	t.Run("TLSConfig should follow rotations of the CA", func(t *testing.T) {
+		client, pki := newPKIClient(t, 4*time.Second)
+		config := vault.PKIConfig{Role: "service", CommonName: "service.internal", RotateBefore: 2 * time.Second}
+
+		serverManager, err := vault.NewCertificateManager(ctx, client, config)
+		assert.NoError(t, err)
+		defer serverManager.Close()
+		clientManager, err := vault.NewCertificateManager(ctx, client, config)
+		assert.NoError(t, err)
+		defer clientManager.Close()
+
+		serverConfig, clientConfig := serverManager.TLSConfig(), clientManager.TLSConfig()
+		pki.rotateCA()
+		serverFirst, clientFirst := serverManager.Certificate(), clientManager.Certificate()
+		assert.Eventually(t, func() bool {
+			return serverManager.Certificate() != serverFirst && clientManager.Certificate() != clientFirst
+		}, 4*time.Second, 10*time.Millisecond)
+
+		serverSerial, clientSerial := handshake(t, serverConfig, clientConfig)
+		assert.Equal(t, serverManager.Certificate().Leaf.SerialNumber.Int64(), serverSerial)
+		assert.Equal(t, clientManager.Certificate().Leaf.SerialNumber.Int64(), clientSerial)
+	})
+
+	t.Run("TLSConfig should reject certificates of another CA", func(t *testing.T) {
+		client, _ := newPKIClient(t, time.Hour)
+		otherClient, _ := newPKIClient(t, time.Hour)
+
+		manager, err := vault.NewCertificateManager(ctx, client, config)
+		assert.NoError(t, err)
+		defer manager.Close()
+		otherManager, err := vault.NewCertificateManager(ctx, otherClient, config)
+		assert.NoError(t, err)
+		defer otherManager.Close()
+
+		serverConn, clientConn := net.Pipe()
+		defer serverConn.Close()
+		defer clientConn.Close()
+		go tls.Server(serverConn, otherManager.TLSConfig()).Handshake()
+
+		clientConfig := manager.TLSConfig()
+		clientConfig.ServerName = "service.internal"
+		assert.Error(t, tls.Client(clientConn, clientConfig).Handshake())
+	})
+
+	t.Run("TLSConfig should verify the host of the server", func(t *testing.T) {
+		client, _ := newPKIClient(t, time.Hour)
+
+		manager, err := vault.NewCertificateManager(ctx, client, config)
+		assert.NoError(t, err)
+		defer manager.Close()
+
+		for serverName, expected := range map[string]string{
+			"":               "ServerName is required",
+			"other.internal": "certificate is valid for",
+		} {
+			serverConn, clientConn := net.Pipe()
+			go tls.Server(serverConn, manager.TLSConfig()).Handshake()
+
+			clientConfig := manager.TLSConfig()
+			clientConfig.ServerName = serverName
+			assert.ErrorContains(t, tls.Client(clientConn, clientConfig).Handshake(), expected)
+			serverConn.Close()
+			clientConn.Close()
+		}
+	})
+
+	t.Run("RotateBefore should not make certificates be reissued back-to-back", func(t *testing.T) {
+		client, pki := newPKIClient(t, time.Hour)
+
+		_, err := vault.NewCertificateManager(ctx, client, vault.PKIConfig{
+			Role:         "service",
+			CommonName:   "service.internal",
+			TTL:          time.Minute,
+			RotateBefore: time.Minute,
+		})
+		assert.ErrorIs(t, err, vault.ErrInvalidCertificate)
+		assert.Equal(t, int32(0), pki.Issued())
+
+		// The role issues certificates shorter than RotateBefore, which are
+		// replaced every quarter of their validity at most.
+		client, pki = newPKIClient(t, time.Second)
+		start := time.Now()
+		manager, err := vault.NewCertificateManager(ctx, client, vault.PKIConfig{
+			Role:         "service",
+			CommonName:   "service.internal",
+			RotateBefore: 2 * time.Second,
+		})
+		assert.NoError(t, err)
+		defer manager.Close()
+
+		assert.Eventually(t, func() bool { return pki.Issued() >= 3 }, 2*time.Second, time.Millisecond)
+		assert.GreaterOrEqual(t, time.Since(start), 500*time.Millisecond)
+	})
+}
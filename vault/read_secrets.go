@@ -0,0 +1,202 @@
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/koanf/v2"
+)
+
+var ErrInvalidSecrets = errors.New("invalid secrets")
+
+// SecretKeyError names the secret key of a client that failed validation.
+// It wraps ErrInvalidSecrets.
+type SecretKeyError struct {
+	ClientSlug string
+	// Key is the dotted path of the secret, e.g. "smtp.host".
+	Key string
+	// Tag is the validation that failed, e.g. "required".
+	Tag string
+}
+
+func (e *SecretKeyError) Error() string {
+	if e.Tag == "required" {
+		return fmt.Sprintf("secret %s of client %s is missing", e.Key, e.ClientSlug)
+	}
+	return fmt.Sprintf("secret %s of client %s failed the %s validation", e.Key, e.ClientSlug, e.Tag)
+}
+
+func (e *SecretKeyError) Unwrap() error {
+	return ErrInvalidSecrets
+}
+
+type readOptions struct {
+	validate *validator.Validate
+}
+
+type ReadOption func(*readOptions)
+
+// WithValidator validates the secrets with validate instead of the default
+// validator, e.g. one with custom rules registered. A nil validate keeps the
+// default.
+func WithValidator(validate *validator.Validate) ReadOption {
+	return func(o *readOptions) {
+		if validate != nil {
+			o.validate = validate
+		}
+	}
+}
+
+// ReadSecrets reads the secrets of the client into a new T, which must be a
+// struct, like ReadSecretsWithContext.
+func ReadSecrets[T any](ctx context.Context, service IVaultService, clientSlug string, opts ...ReadOption) (T, error) {
+	var dest T
+	err := service.ReadSecretsWithContext(ctx, clientSlug, &dest, opts...)
+	return dest, err
+}
+
+// decodeSecrets decodes the secrets of the client into dest. Nested structs
+// are read from dotted keys like "smtp.host" or from JSON-encoded values,
+// missing keys take the value of the default tag of their field, and every
+// validation error names the key that failed.
+func decodeSecrets(clientSlug string, secrets map[string]interface{}, dest interface{}, validate *validator.Validate) error {
+	k := koanf.New(".")
+	if err := k.Load(confmap.Provider(secrets, "."), nil); err != nil {
+		return err
+	}
+
+	values := k.Raw()
+	keys := map[string]string{}
+	destType := reflect.TypeOf(dest)
+	for destType.Kind() == reflect.Ptr {
+		destType = destType.Elem()
+	}
+	if destType.Kind() == reflect.Struct {
+		if err := prepareSecrets(destType, values, "", "", keys); err != nil {
+			return fmt.Errorf("%w: client %s: %v", ErrInvalidSecrets, clientSlug, err)
+		}
+	}
+
+	k = koanf.New(".")
+	if err := k.Load(confmap.Provider(values, ""), nil); err != nil {
+		return err
+	}
+	if err := k.Unmarshal("", dest); err != nil {
+		return fmt.Errorf("%w: unable to decode secrets of client %s: %v", ErrInvalidSecrets, clientSlug, err)
+	}
+
+	err := validate.Struct(dest)
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		return err
+	}
+
+	errs := make([]error, 0, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		namespace := fieldError.StructNamespace()
+		if i := strings.Index(namespace, "."); i >= 0 {
+			namespace = namespace[i+1:]
+		}
+		key, ok := keys[namespace]
+		if !ok {
+			key = namespace
+		}
+		errs = append(errs, &SecretKeyError{ClientSlug: clientSlug, Key: key, Tag: fieldError.Tag()})
+	}
+	return errors.Join(errs...)
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// prepareSecrets walks the fields of t to decode JSON-encoded values of
+// nested fields and fill in defaults, recording the dotted key of every field
+// by its struct namespace.
+func prepareSecrets(t reflect.Type, values map[string]interface{}, namespace string, prefix string, keys map[string]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("koanf"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		key := lookupKey(values, name)
+		fieldNamespace := namespace + field.Name
+		keys[fieldNamespace] = prefix + key
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+
+		value, exists := values[key]
+		if !exists {
+			if defaultValue, ok := field.Tag.Lookup("default"); ok {
+				values[key] = defaultValue
+				value = defaultValue
+			}
+		}
+
+		if encoded, ok := value.(string); ok && isNested(fieldType) {
+			trimmed := strings.TrimSpace(encoded)
+			if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+				var decoded interface{}
+				if err := json.Unmarshal([]byte(trimmed), &decoded); err != nil {
+					return fmt.Errorf("secret %s is not valid JSON: %v", prefix+key, err)
+				}
+				values[key] = decoded
+				value = decoded
+			}
+		}
+
+		if fieldType.Kind() == reflect.Struct && fieldType != timeType {
+			nested, ok := value.(map[string]interface{})
+			if !ok {
+				nested = map[string]interface{}{}
+			}
+			if err := prepareSecrets(fieldType, nested, fieldNamespace+".", prefix+key+".", keys); err != nil {
+				return err
+			}
+			if len(nested) > 0 {
+				values[key] = nested
+			}
+		}
+	}
+	return nil
+}
+
+// lookupKey returns the key of values matching name, ignoring case like the
+// decoder does, or name itself.
+func lookupKey(values map[string]interface{}, name string) string {
+	if _, ok := values[name]; ok {
+		return name
+	}
+	for key := range values {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return name
+}
+
+func isNested(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct:
+		return t != timeType
+	case reflect.Map, reflect.Slice, reflect.Array:
+		return true
+	}
+	return false
+}
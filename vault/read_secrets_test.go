@@ -0,0 +1,121 @@
+package vault_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadSecrets(t *testing.T) {
+	ctx := context.Background()
+
+	type SMTP struct {
+		Host string `koanf:"host" validate:"required"`
+		Port int    `koanf:"port" default:"587"`
+	}
+
+	type Secrets struct {
+		Name    string         `koanf:"NAME" validate:"required"`
+		Timeout time.Duration  `koanf:"TIMEOUT" default:"5s"`
+		SMTP    SMTP           `koanf:"smtp"`
+		Tags    []string       `koanf:"TAGS"`
+		Limits  map[string]int `koanf:"LIMITS"`
+	}
+
+	newService := func(secrets map[string]interface{}) vault.IVaultService {
+		return vault.NewVaultService(vault.NewMockVaultRepository(vault.VaultMockData{"client1": secrets}))
+	}
+
+	t.Run("ReadSecrets should return the typed secrets", func(t *testing.T) {
+		service := newService(map[string]interface{}{
+			"NAME":      "client",
+			"TIMEOUT":   "10s",
+			"smtp.host": "smtp.example.com",
+			"smtp.port": "25",
+		})
+
+		secrets, err := vault.ReadSecrets[Secrets](ctx, service, "client1")
+		assert.NoError(t, err)
+		assert.Equal(t, Secrets{
+			Name:    "client",
+			Timeout: 10 * time.Second,
+			SMTP:    SMTP{Host: "smtp.example.com", Port: 25},
+		}, secrets)
+	})
+
+	t.Run("nested values should be decoded from JSON", func(t *testing.T) {
+		service := newService(map[string]interface{}{
+			"NAME":   "client",
+			"smtp":   `{"host": "smtp.example.com", "port": 465}`,
+			"TAGS":   `["a", "b"]`,
+			"LIMITS": `{"users": 10}`,
+		})
+
+		secrets, err := vault.ReadSecrets[Secrets](ctx, service, "client1")
+		assert.NoError(t, err)
+		assert.Equal(t, SMTP{Host: "smtp.example.com", Port: 465}, secrets.SMTP)
+		assert.Equal(t, []string{"a", "b"}, secrets.Tags)
+		assert.Equal(t, map[string]int{"users": 10}, secrets.Limits)
+
+		_, err = vault.ReadSecrets[Secrets](ctx, newService(map[string]interface{}{"smtp": `{"host":`}), "client1")
+		assert.ErrorIs(t, err, vault.ErrInvalidSecrets)
+		assert.ErrorContains(t, err, "secret smtp is not valid JSON")
+	})
+
+	t.Run("missing keys should take their default", func(t *testing.T) {
+		service := newService(map[string]interface{}{
+			"NAME":      "client",
+			"smtp.host": "smtp.example.com",
+		})
+
+		secrets, err := vault.ReadSecrets[Secrets](ctx, service, "client1")
+		assert.NoError(t, err)
+		assert.Equal(t, 5*time.Second, secrets.Timeout)
+		assert.Equal(t, 587, secrets.SMTP.Port)
+	})
+
+	t.Run("errors should name the key and the client", func(t *testing.T) {
+		service := newService(map[string]interface{}{"TIMEOUT": "1s"})
+
+		_, err := vault.ReadSecrets[Secrets](ctx, service, "client1")
+		assert.ErrorIs(t, err, vault.ErrInvalidSecrets)
+		assert.ErrorContains(t, err, "secret NAME of client client1 is missing")
+		assert.ErrorContains(t, err, "secret smtp.host of client client1 is missing")
+
+		var keyError *vault.SecretKeyError
+		assert.True(t, errors.As(err, &keyError))
+		assert.Equal(t, "client1", keyError.ClientSlug)
+		assert.Equal(t, "required", keyError.Tag)
+	})
+
+	t.Run("WithValidator should apply custom rules", func(t *testing.T) {
+		type Secrets struct {
+			Name string `koanf:"NAME" validate:"lowercase_name"`
+		}
+
+		validate := validator.New()
+		validate.RegisterValidation("lowercase_name", func(fl validator.FieldLevel) bool {
+			return fl.Field().String() == strings.ToLower(fl.Field().String())
+		})
+
+		service := newService(map[string]interface{}{"NAME": "Client"})
+		_, err := vault.ReadSecrets[Secrets](ctx, service, "client1", vault.WithValidator(validate))
+		assert.ErrorContains(t, err, "secret NAME of client client1 failed the lowercase_name validation")
+
+		service = newService(map[string]interface{}{"NAME": "client"})
+		secrets, err := vault.ReadSecrets[Secrets](ctx, service, "client1", vault.WithValidator(validate))
+		assert.NoError(t, err)
+		assert.Equal(t, "client", secrets.Name)
+	})
+
+	t.Run("WithValidator should keep the default validator when nil", func(t *testing.T) {
+		_, err := vault.ReadSecrets[Secrets](ctx, newService(map[string]interface{}{}), "client1", vault.WithValidator(nil))
+		assert.ErrorContains(t, err, "secret NAME of client client1 is missing")
+	})
+}
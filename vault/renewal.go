@@ -0,0 +1,225 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/hashicorp/vault/api"
+)
+
+type AuthState string
+
+const (
+	// AuthStateHealthy means the token is valid and renewed before it expires.
+	AuthStateHealthy AuthState = "healthy"
+	// AuthStateRenewing means the token could not be renewed anymore and a new
+	// login is in progress.
+	AuthStateRenewing AuthState = "renewing"
+	// AuthStateFailing means logins are failing and being retried with backoff.
+	AuthStateFailing AuthState = "failing"
+	AuthStateClosed  AuthState = "closed"
+)
+
+const (
+	defaultBackoffInitial = time.Second
+	defaultBackoffMax     = time.Minute
+)
+
+var ErrVaultAuthFailing = errors.New("vault auth is failing")
+
+// VaultBackoffConfig controls the delay between failed attempts, which doubles
+// after every failure up to Max and is randomized by up to half.
+type VaultBackoffConfig struct {
+	// Initial defaults to one second
+	Initial time.Duration `koanf:"initial"`
+	// Max defaults to one minute
+	Max time.Duration `koanf:"max"`
+}
+
+type AuthStatus struct {
+	State AuthState
+	// Since is when the state last changed.
+	Since time.Time
+	// Err is the last login or renewal error.
+	Err error
+	// Failures counts the logins that failed in a row.
+	Failures int
+}
+
+// HealthCheck fails while logins are failing or after the repository was
+// closed. A token being replaced is still considered healthy.
+func (s AuthStatus) HealthCheck() error {
+	switch s.State {
+	case AuthStateHealthy, AuthStateRenewing:
+		return nil
+	case AuthStateFailing:
+		return fmt.Errorf("%w: %d failed logins since %s: %v", ErrVaultAuthFailing, s.Failures, s.Since.Format(time.RFC3339), s.Err)
+	}
+	return fmt.Errorf("vault auth is %s", s.State)
+}
+
+func (c *vaultRepository) AuthStatus() AuthStatus {
+	c.statusMu.RLock()
+	defer c.statusMu.RUnlock()
+	return c.status
+}
+
+// Close stops the token renewal and waits for it to exit. Requests made after
+// Close fail once the token expires.
+func (c *vaultRepository) Close() error {
+	c.cancel()
+	<-c.renewed
+	c.setAuthState(AuthStateClosed, nil, 0)
+	return nil
+}
+
+func (c *vaultRepository) setAuthState(state AuthState, err error, failures int) {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	if c.status.State == AuthStateClosed {
+		return
+	}
+	if c.status.State != state {
+		c.status.Since = time.Now()
+	}
+	c.status.State = state
+	c.status.Err = err
+	c.status.Failures = failures
+}
+
+// renewToken keeps the token of authInfo renewed, logging in again whenever it
+// cannot be renewed anymore, until the repository is closed.
+func (c *vaultRepository) renewToken(authInfo *api.Secret) {
+	defer close(c.renewed)
+
+	failures := 0
+	for {
+		if authInfo == nil {
+			ctx, span := utils.Tracer.NewSpan(c.ctx, "vault", "Vault Token Renewal")
+
+			var err error
+			authInfo, err = c.login(ctx)
+			if err != nil {
+				if c.ctx.Err() != nil {
+					span.End()
+					return
+				}
+
+				failures++
+				c.setAuthState(AuthStateFailing, err, failures)
+				utils.Tracer.AddSpanErrorAndFail(span, err, "unable to authenticate to Vault")
+				span.End()
+
+				if !sleep(c.ctx, c.config.LoginBackoff.delay(failures)) {
+					return
+				}
+				continue
+			}
+			span.End()
+
+			failures = 0
+			c.setAuthState(AuthStateHealthy, nil, 0)
+		}
+
+		err := c.manageTokenLifecycle(authInfo)
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.setAuthState(AuthStateRenewing, err, 0)
+		authInfo = nil
+	}
+}
+
+// delay returns the delay after the given number of failed attempts.
+func (b VaultBackoffConfig) delay(failures int) time.Duration {
+	initial := b.Initial
+	if initial <= 0 {
+		initial = defaultBackoffInitial
+	}
+	max := b.Max
+	if max <= 0 {
+		max = defaultBackoffMax
+	}
+
+	delay := initial
+	for i := 1; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sleep waits for d and reports false if ctx was done meanwhile.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// manageTokenLifecycle returns when the token must be replaced by a new login,
+// with the error that stopped its renewal if any.
+func (c *vaultRepository) manageTokenLifecycle(token *api.Secret) error {
+	if token.Auth == nil {
+		return fmt.Errorf("no auth info was returned after login")
+	}
+
+	if !token.Auth.Renewable {
+		_, span := utils.Tracer.NewSpan(c.ctx, "vault", "Vault Token Renewal")
+		utils.Tracer.AddSpanEvents(span, "Token not renewable", map[string]string{"message": "Token is not configured to be renewable. Re-attempting login before it expires."})
+		span.End()
+
+		// Tokens without a TTL, like the ones usually given to the token auth
+		// method, never expire.
+		if token.Auth.LeaseDuration <= 0 {
+			<-c.ctx.Done()
+			return nil
+		}
+		sleep(c.ctx, time.Duration(token.Auth.LeaseDuration)*time.Second*9/10)
+		return nil
+	}
+
+	watcher, err := c.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret:    token,
+		Increment: 3600,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to initialize new lifetime watcher for renewing auth token: %w", err)
+	}
+
+	go watcher.Start()
+	defer watcher.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return nil
+
+		case err := <-watcher.DoneCh():
+			_, span := utils.Tracer.NewSpan(c.ctx, "vault", "Vault Token Renewal")
+			defer span.End()
+			if err != nil {
+				utils.Tracer.AddSpanEvents(span, "Failed to renew token", map[string]string{"message": fmt.Sprintf("Failed to renew token: %v. Re-attempting login.", err)})
+				return err
+			}
+			utils.Tracer.AddSpanEvents(span, "Failed to renew token", map[string]string{"message": "Token can no longer be renewed. Re-attempting login."})
+			return nil
+
+		case renewal := <-watcher.RenewCh():
+			_, span := utils.Tracer.NewSpan(c.ctx, "vault", "Vault Token Renewal")
+			utils.Tracer.AddSpanEvents(span, "Token renewed", map[string]string{"message": fmt.Sprintf("Successfully renewed: %#v", renewal)})
+			span.End()
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package vault_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVaultRepositoryRenewal(t *testing.T) {
+	var (
+		failing int32
+		logins  int32
+		closed  int32
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&closed) == 1 {
+			t.Error("login after Close")
+		}
+		atomic.AddInt32(&logins, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"vault is down"}})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": "token", "renewable": false, "lease_duration": 1}})
+	}))
+	defer server.Close()
+
+	repo, err := vault.NewVaultRepository(&vault.VaultConfig{
+		RoleId:       "role",
+		SecretId:     "secret",
+		Url:          server.URL,
+		MountPath:    "secret",
+		Cert:         writeServerCert(t, server),
+		LoginBackoff: vault.VaultBackoffConfig{Initial: 10 * time.Millisecond, Max: 40 * time.Millisecond},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, vault.AuthStateHealthy, repo.AuthStatus().State)
+	assert.NoError(t, repo.AuthStatus().HealthCheck())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&logins))
+
+	atomic.StoreInt32(&failing, 1)
+	assert.Eventually(t, func() bool {
+		return repo.AuthStatus().Failures >= 3
+	}, 3*time.Second, 10*time.Millisecond)
+
+	status := repo.AuthStatus()
+	assert.Equal(t, vault.AuthStateFailing, status.State)
+	assert.Error(t, status.Err)
+	assert.ErrorIs(t, status.HealthCheck(), vault.ErrVaultAuthFailing)
+
+	atomic.StoreInt32(&failing, 0)
+	assert.Eventually(t, func() bool {
+		return repo.AuthStatus().State == vault.AuthStateHealthy
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, repo.AuthStatus().Failures)
+
+	// Close waits for the renewal to exit, so the server fails the test on
+	// any login after it.
+	stopped := make(chan struct{})
+	go func() {
+		assert.NoError(t, repo.Close())
+		atomic.StoreInt32(&closed, 1)
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Close did not stop the renewal")
+	}
+	assert.Equal(t, vault.AuthStateClosed, repo.AuthStatus().State)
+	assert.Error(t, repo.AuthStatus().HealthCheck())
+}
+
+func TestVaultRepositoryCloseAfterFailedLogin(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{"invalid role or secret ID"}})
+	}))
+	defer server.Close()
+
+	repo, err := vault.NewVaultRepository(&vault.VaultConfig{
+		RoleId:    "role",
+		SecretId:  "wrong",
+		Url:       server.URL,
+		MountPath: "secret",
+		Cert:      writeServerCert(t, server),
+	})
+	assert.ErrorContains(t, err, "invalid role or secret ID")
+
+	closed := make(chan struct{})
+	go func() {
+		assert.NoError(t, repo.Close())
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked after a failed login")
+	}
+}
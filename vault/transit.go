@@ -0,0 +1,258 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/hashicorp/vault/api"
+)
+
+const defaultTransitMountPath = "transit"
+
+var (
+	ErrInvalidCiphertext = errors.New("invalid ciphertext")
+	// ErrTransitBatchItem is wrapped by the error of a batch with the index of
+	// the first item that failed.
+	ErrTransitBatchItem = errors.New("transit batch item failed")
+)
+
+type TransitConfig struct {
+	// MountPath of the transit secrets engine, defaults to "transit"
+	MountPath string `koanf:"mount_path"`
+	// KeyPrefix is prepended to the client slug to name its key
+	KeyPrefix string `koanf:"key_prefix"`
+}
+
+// DataKey is a key for envelope encryption. Plaintext encrypts the data
+// locally and must not be stored, Ciphertext is stored along the data and
+// turned back into Plaintext with Decrypt.
+type DataKey struct {
+	Plaintext  []byte
+	Ciphertext string
+	KeyVersion int
+}
+
+// ITransitService encrypts data with a transit key per client slug, so the
+// keys never leave Vault. Ciphertexts are prefixed with the key version, like
+// "vault:v1:...".
+type ITransitService interface {
+	Encrypt(ctx context.Context, clientSlug string, plaintext []byte) (string, error)
+	Decrypt(ctx context.Context, clientSlug string, ciphertext string) ([]byte, error)
+	EncryptBatch(ctx context.Context, clientSlug string, plaintexts [][]byte) ([]string, error)
+	DecryptBatch(ctx context.Context, clientSlug string, ciphertexts []string) ([][]byte, error)
+	// Rewrap encrypts ciphertext again with the latest version of the key
+	// without exposing the plaintext, e.g. after the key was rotated.
+	Rewrap(ctx context.Context, clientSlug string, ciphertext string) (string, error)
+	RewrapBatch(ctx context.Context, clientSlug string, ciphertexts []string) ([]string, error)
+	GenerateDataKey(ctx context.Context, clientSlug string) (DataKey, error)
+}
+
+type transitService struct {
+	client *api.Client
+	config TransitConfig
+}
+
+// NewTransitService uses the transit engine through client, usually the one of
+// the repository, see ClientProvider. Keys are created by Vault on the first
+// encryption when the policy of the token allows it.
+func NewTransitService(client *api.Client, config TransitConfig) ITransitService {
+	return &transitService{client: client, config: config}
+}
+
+func (s *transitService) path(operation string, clientSlug string) string {
+	return fmt.Sprintf("%s/%s/%s%s", withDefault(s.config.MountPath, defaultTransitMountPath), operation, s.config.KeyPrefix, clientSlug)
+}
+
+func (s *transitService) write(ctx context.Context, spanName string, operation string, clientSlug string, data map[string]interface{}) (map[string]interface{}, error) {
+	ctx, span := newSpan(ctx, spanName, clientSlug)
+	defer span.End()
+
+	secret, err := s.client.Logical().WriteWithContext(ctx, s.path(operation, clientSlug), data)
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to "+operation)
+		return nil, fmt.Errorf("unable to %s for client %s: %w", operation, clientSlug, err)
+	}
+	if secret == nil || secret.Data == nil {
+		err := fmt.Errorf("unable to %s for client %s: empty response", operation, clientSlug)
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to "+operation)
+		return nil, err
+	}
+	return secret.Data, nil
+}
+
+func (s *transitService) writeBatch(ctx context.Context, spanName string, operation string, clientSlug string, input []map[string]interface{}, field string) ([]string, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+
+	ctx, span := newSpan(ctx, spanName, clientSlug)
+	defer span.End()
+
+	// Vault answers batches where some items failed with the code asked by
+	// partial_failure_response_code, but batches where every item failed
+	// with 400 and the error of each item in batch_results, which the client
+	// drops, so the body of failed responses is kept.
+	var failedBody []byte
+	client := s.client.WithResponseCallbacks(func(resp *api.Response) {
+		if resp.StatusCode >= http.StatusBadRequest {
+			failedBody, _ = io.ReadAll(resp.Body)
+			resp.Body = io.NopCloser(bytes.NewReader(failedBody))
+		}
+	})
+	secret, err := client.Logical().WriteWithContext(ctx, s.path(operation, clientSlug), map[string]interface{}{
+		"batch_input":                   input,
+		"partial_failure_response_code": http.StatusOK,
+	})
+	if err != nil && failedBody != nil {
+		if failed, parseErr := api.ParseSecret(bytes.NewReader(failedBody)); parseErr == nil && failed != nil && failed.Data["batch_results"] != nil {
+			secret, err = failed, nil
+		}
+	}
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to "+operation)
+		return nil, fmt.Errorf("unable to %s for client %s: %w", operation, clientSlug, err)
+	}
+
+	var results []interface{}
+	if secret != nil {
+		results, _ = secret.Data["batch_results"].([]interface{})
+	}
+	if len(results) != len(input) {
+		err := fmt.Errorf("unable to %s for client %s: %d results for %d items", operation, clientSlug, len(results), len(input))
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to "+operation)
+		return nil, err
+	}
+
+	values := make([]string, len(results))
+	for i, result := range results {
+		item, _ := result.(map[string]interface{})
+		if message, _ := item["error"].(string); message != "" {
+			err := fmt.Errorf("%w: %s item %d for client %s: %s", ErrTransitBatchItem, operation, i, clientSlug, message)
+			utils.Tracer.AddSpanErrorAndFail(span, err, "unable to "+operation)
+			return nil, err
+		}
+		values[i], _ = item[field].(string)
+	}
+	return values, nil
+}
+
+func (s *transitService) Encrypt(ctx context.Context, clientSlug string, plaintext []byte) (string, error) {
+	data, err := s.write(ctx, "Vault Transit Encrypt", "encrypt", clientSlug, map[string]interface{}{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	})
+	if err != nil {
+		return "", err
+	}
+	ciphertext, _ := data["ciphertext"].(string)
+	return ciphertext, nil
+}
+
+func (s *transitService) Decrypt(ctx context.Context, clientSlug string, ciphertext string) ([]byte, error) {
+	data, err := s.write(ctx, "Vault Transit Decrypt", "decrypt", clientSlug, map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	plaintext, _ := data["plaintext"].(string)
+	return decodePlaintext(plaintext)
+}
+
+func (s *transitService) EncryptBatch(ctx context.Context, clientSlug string, plaintexts [][]byte) ([]string, error) {
+	input := make([]map[string]interface{}, len(plaintexts))
+	for i, plaintext := range plaintexts {
+		input[i] = map[string]interface{}{"plaintext": base64.StdEncoding.EncodeToString(plaintext)}
+	}
+	return s.writeBatch(ctx, "Vault Transit Encrypt Batch", "encrypt", clientSlug, input, "ciphertext")
+}
+
+func (s *transitService) DecryptBatch(ctx context.Context, clientSlug string, ciphertexts []string) ([][]byte, error) {
+	encoded, err := s.writeBatch(ctx, "Vault Transit Decrypt Batch", "decrypt", clientSlug, ciphertextInput(ciphertexts), "plaintext")
+	if err != nil || encoded == nil {
+		return nil, err
+	}
+
+	plaintexts := make([][]byte, len(encoded))
+	for i, plaintext := range encoded {
+		if plaintexts[i], err = decodePlaintext(plaintext); err != nil {
+			return nil, err
+		}
+	}
+	return plaintexts, nil
+}
+
+func (s *transitService) Rewrap(ctx context.Context, clientSlug string, ciphertext string) (string, error) {
+	data, err := s.write(ctx, "Vault Transit Rewrap", "rewrap", clientSlug, map[string]interface{}{
+		"ciphertext": ciphertext,
+	})
+	if err != nil {
+		return "", err
+	}
+	rewrapped, _ := data["ciphertext"].(string)
+	return rewrapped, nil
+}
+
+func (s *transitService) RewrapBatch(ctx context.Context, clientSlug string, ciphertexts []string) ([]string, error) {
+	return s.writeBatch(ctx, "Vault Transit Rewrap Batch", "rewrap", clientSlug, ciphertextInput(ciphertexts), "ciphertext")
+}
+
+func (s *transitService) GenerateDataKey(ctx context.Context, clientSlug string) (DataKey, error) {
+	data, err := s.write(ctx, "Vault Transit Generate Data Key", "datakey/plaintext", clientSlug, map[string]interface{}{})
+	if err != nil {
+		return DataKey{}, err
+	}
+
+	encoded, _ := data["plaintext"].(string)
+	plaintext, err := decodePlaintext(encoded)
+	if err != nil {
+		return DataKey{}, err
+	}
+	ciphertext, _ := data["ciphertext"].(string)
+	version, err := ciphertextVersion(ciphertext)
+	if err != nil {
+		return DataKey{}, err
+	}
+	return DataKey{Plaintext: plaintext, Ciphertext: ciphertext, KeyVersion: version}, nil
+}
+
+func ciphertextInput(ciphertexts []string) []map[string]interface{} {
+	input := make([]map[string]interface{}, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		input[i] = map[string]interface{}{"ciphertext": ciphertext}
+	}
+	return input
+}
+
+func decodePlaintext(plaintext string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode plaintext: %w", err)
+	}
+	return decoded, nil
+}
+
+// splitCiphertext splits a "vault:v<version>:<payload>" ciphertext.
+func splitCiphertext(ciphertext string) (int, string, error) {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" || !strings.HasPrefix(parts[1], "v") {
+		return 0, "", ErrInvalidCiphertext
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[1], "v%d", &version); err != nil || version < 1 {
+		return 0, "", ErrInvalidCiphertext
+	}
+	return version, parts[2], nil
+}
+
+func ciphertextVersion(ciphertext string) (int, error) {
+	version, _, err := splitCiphertext(ciphertext)
+	return version, err
+}
@@ -0,0 +1,195 @@
+package vault
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+)
+
+// transitMockService encrypts with AES-GCM keys kept in memory, with the same
+// ciphertext format as the transit engine.
+type transitMockService struct {
+	keys map[string][]cipher.AEAD
+	mu   sync.Mutex
+}
+
+var _ ITransitService = (*transitMockService)(nil)
+
+func NewMockTransitService() *transitMockService {
+	return &transitMockService{keys: make(map[string][]cipher.AEAD)}
+}
+
+// RotateKey adds a new version of the key of clientSlug, which is used to
+// encrypt from now on.
+func (s *transitMockService) RotateKey(clientSlug string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.rotateKey(clientSlug)
+	return err
+}
+
+func (s *transitMockService) rotateKey(clientSlug string) (cipher.AEAD, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	s.keys[clientSlug] = append(s.keys[clientSlug], aead)
+	return aead, nil
+}
+
+func (s *transitMockService) encrypt(clientSlug string, plaintext []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.keys[clientSlug]
+	if len(versions) == 0 {
+		if _, err := s.rotateKey(clientSlug); err != nil {
+			return "", err
+		}
+		versions = s.keys[clientSlug]
+	}
+	aead := versions[len(versions)-1]
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return fmt.Sprintf("vault:v%d:%s", len(versions), base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+func (s *transitMockService) decrypt(clientSlug string, ciphertext string) ([]byte, error) {
+	version, payload, err := splitCiphertext(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+
+	s.mu.Lock()
+	versions := s.keys[clientSlug]
+	s.mu.Unlock()
+	if version > len(versions) {
+		return nil, fmt.Errorf("%w: unknown key version %d for client %s", ErrInvalidCiphertext, version, clientSlug)
+	}
+
+	aead := versions[version-1]
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCiphertext, err)
+	}
+	return plaintext, nil
+}
+
+func (s *transitMockService) Encrypt(ctx context.Context, clientSlug string, plaintext []byte) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return s.encrypt(clientSlug, plaintext)
+}
+
+func (s *transitMockService) Decrypt(ctx context.Context, clientSlug string, ciphertext string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.decrypt(clientSlug, ciphertext)
+}
+
+func (s *transitMockService) EncryptBatch(ctx context.Context, clientSlug string, plaintexts [][]byte) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ciphertexts := make([]string, len(plaintexts))
+	for i, plaintext := range plaintexts {
+		ciphertext, err := s.encrypt(clientSlug, plaintext)
+		if err != nil {
+			return nil, fmt.Errorf("%w: encrypt item %d for client %s: %v", ErrTransitBatchItem, i, clientSlug, err)
+		}
+		ciphertexts[i] = ciphertext
+	}
+	return ciphertexts, nil
+}
+
+func (s *transitMockService) DecryptBatch(ctx context.Context, clientSlug string, ciphertexts []string) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	plaintexts := make([][]byte, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		plaintext, err := s.decrypt(clientSlug, ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("%w: decrypt item %d for client %s: %v", ErrTransitBatchItem, i, clientSlug, err)
+		}
+		plaintexts[i] = plaintext
+	}
+	return plaintexts, nil
+}
+
+func (s *transitMockService) Rewrap(ctx context.Context, clientSlug string, ciphertext string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	plaintext, err := s.decrypt(clientSlug, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return s.encrypt(clientSlug, plaintext)
+}
+
+func (s *transitMockService) RewrapBatch(ctx context.Context, clientSlug string, ciphertexts []string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rewrapped := make([]string, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		plaintext, err := s.decrypt(clientSlug, ciphertext)
+		if err == nil {
+			rewrapped[i], err = s.encrypt(clientSlug, plaintext)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: rewrap item %d for client %s: %v", ErrTransitBatchItem, i, clientSlug, err)
+		}
+	}
+	return rewrapped, nil
+}
+
+func (s *transitMockService) GenerateDataKey(ctx context.Context, clientSlug string) (DataKey, error) {
+	if err := ctx.Err(); err != nil {
+		return DataKey{}, err
+	}
+
+	plaintext := make([]byte, 32)
+	if _, err := rand.Read(plaintext); err != nil {
+		return DataKey{}, err
+	}
+	ciphertext, err := s.encrypt(clientSlug, plaintext)
+	if err != nil {
+		return DataKey{}, err
+	}
+	version, err := ciphertextVersion(ciphertext)
+	if err != nil {
+		return DataKey{}, err
+	}
+	return DataKey{Plaintext: plaintext, Ciphertext: ciphertext, KeyVersion: version}, nil
+}
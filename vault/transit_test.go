@@ -0,0 +1,188 @@
+package vault_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type transitItem struct {
+	Plaintext  string `json:"plaintext,omitempty"`
+	Ciphertext string `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// newTransitServer answers transit requests for keys named "tenant-<slug>"
+// with the in-memory transit service. Batches with failed items are answered
+// like Vault does: 400 when every item failed, and the code asked by
+// partial_failure_response_code, or 400, when some did.
+func newTransitServer(t *testing.T, fake vault.ITransitService) *api.Client {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		path := strings.TrimPrefix(r.URL.Path, "/v1/transit/")
+		slash := strings.LastIndex(path, "/")
+		operation, slug := path[:slash], strings.TrimPrefix(path[slash+1:], "tenant-")
+
+		var request struct {
+			transitItem
+			BatchInput                 []transitItem `json:"batch_input"`
+			PartialFailureResponseCode int           `json:"partial_failure_response_code"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+
+		process := func(item transitItem) transitItem {
+			var result transitItem
+			var err error
+			switch operation {
+			case "encrypt":
+				plaintext, _ := base64.StdEncoding.DecodeString(item.Plaintext)
+				result.Ciphertext, err = fake.Encrypt(ctx, slug, plaintext)
+			case "decrypt":
+				var plaintext []byte
+				plaintext, err = fake.Decrypt(ctx, slug, item.Ciphertext)
+				result.Plaintext = base64.StdEncoding.EncodeToString(plaintext)
+			case "rewrap":
+				result.Ciphertext, err = fake.Rewrap(ctx, slug, item.Ciphertext)
+			case "datakey/plaintext":
+				var key vault.DataKey
+				key, err = fake.GenerateDataKey(ctx, slug)
+				result.Plaintext, result.Ciphertext = base64.StdEncoding.EncodeToString(key.Plaintext), key.Ciphertext
+			}
+			if err != nil {
+				result.Error = err.Error()
+			}
+			return result
+		}
+
+		var data interface{}
+		if request.BatchInput != nil {
+			results := make([]transitItem, len(request.BatchInput))
+			failed := 0
+			for i, item := range request.BatchInput {
+				results[i] = process(item)
+				if results[i].Error != "" {
+					failed++
+				}
+			}
+			switch {
+			case failed == len(results):
+				w.WriteHeader(http.StatusBadRequest)
+			case failed > 0 && request.PartialFailureResponseCode != 0:
+				w.WriteHeader(request.PartialFailureResponseCode)
+			case failed > 0:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+			data = map[string]interface{}{"batch_results": results}
+		} else {
+			result := process(request.transitItem)
+			if result.Error != "" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]interface{}{"errors": []string{result.Error}})
+				return
+			}
+			data = result
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL, HttpClient: server.Client()})
+	assert.NoError(t, err)
+	return client
+}
+
+func TestTransitService(t *testing.T) {
+	mock := vault.NewMockTransitService()
+	services := map[string]vault.ITransitService{
+		"mock":  mock,
+		"vault": vault.NewTransitService(newTransitServer(t, mock), vault.TransitConfig{KeyPrefix: "tenant-"}),
+	}
+
+	for name, service := range services {
+		service := service
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("Encrypt and Decrypt should round trip", func(t *testing.T) {
+				ciphertext, err := service.Encrypt(ctx, "client1", []byte("123.456.789-00"))
+				assert.NoError(t, err)
+				assert.True(t, strings.HasPrefix(ciphertext, "vault:v1:"))
+
+				plaintext, err := service.Decrypt(ctx, "client1", ciphertext)
+				assert.NoError(t, err)
+				assert.Equal(t, []byte("123.456.789-00"), plaintext)
+			})
+
+			t.Run("ciphertexts should not be decrypted with the key of another client", func(t *testing.T) {
+				ciphertext, err := service.Encrypt(ctx, "client1", []byte("secret"))
+				assert.NoError(t, err)
+
+				_, err = service.Encrypt(ctx, "client2", []byte("other"))
+				assert.NoError(t, err)
+				_, err = service.Decrypt(ctx, "client2", ciphertext)
+				assert.Error(t, err)
+			})
+
+			t.Run("batches should round trip in order", func(t *testing.T) {
+				ciphertexts, err := service.EncryptBatch(ctx, "client1", [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+				assert.NoError(t, err)
+				assert.Len(t, ciphertexts, 3)
+
+				plaintexts, err := service.DecryptBatch(ctx, "client1", ciphertexts)
+				assert.NoError(t, err)
+				assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, plaintexts)
+			})
+
+			t.Run("batches should fail naming the failed item", func(t *testing.T) {
+				ciphertext, err := service.Encrypt(ctx, "client1", []byte("a"))
+				assert.NoError(t, err)
+
+				_, err = service.DecryptBatch(ctx, "client1", []string{ciphertext, "vault:v1:invalid"})
+				assert.ErrorIs(t, err, vault.ErrTransitBatchItem)
+				assert.ErrorContains(t, err, "item 1")
+
+				_, err = service.DecryptBatch(ctx, "client1", []string{"vault:v1:invalid", "vault:v1:invalid"})
+				assert.ErrorIs(t, err, vault.ErrTransitBatchItem)
+				assert.ErrorContains(t, err, "item 0")
+			})
+
+			t.Run("Rewrap should use the latest key version", func(t *testing.T) {
+				ciphertext, err := service.Encrypt(ctx, "rotated", []byte("phone"))
+				assert.NoError(t, err)
+				assert.NoError(t, mock.RotateKey("rotated"))
+
+				rewrapped, err := service.Rewrap(ctx, "rotated", ciphertext)
+				assert.NoError(t, err)
+				assert.NotEqual(t, ciphertext, rewrapped)
+
+				batch, err := service.RewrapBatch(ctx, "rotated", []string{ciphertext, rewrapped})
+				assert.NoError(t, err)
+				for _, ciphertext := range append(batch, rewrapped) {
+					assert.Regexp(t, `^vault:v[2-9]:`, ciphertext)
+					plaintext, err := service.Decrypt(ctx, "rotated", ciphertext)
+					assert.NoError(t, err)
+					assert.Equal(t, []byte("phone"), plaintext)
+				}
+			})
+
+			t.Run("data keys should be decrypted from their ciphertext", func(t *testing.T) {
+				key, err := service.GenerateDataKey(ctx, "client1")
+				assert.NoError(t, err)
+				assert.Len(t, key.Plaintext, 32)
+				assert.Equal(t, 1, key.KeyVersion)
+
+				plaintext, err := service.Decrypt(ctx, "client1", key.Ciphertext)
+				assert.NoError(t, err)
+				assert.Equal(t, key.Plaintext, plaintext)
+			})
+		})
+	}
+}
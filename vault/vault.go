@@ -1,79 +1,208 @@
 package vault
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"os"
+	"reflect"
+	"time"
 
+	utils "github.com/criticalmassbr/ms-utils"
+	"github.com/criticalmassbr/ms-utils/cache"
 	"github.com/criticalmassbr/ms-utils/typed_sync_map"
 	"github.com/go-playground/validator/v10"
-	"github.com/knadh/koanf/providers/confmap"
-	"github.com/knadh/koanf/v2"
+	"github.com/hashicorp/vault/api"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type VaultMockConfig struct {
-	Enabled  bool   `koanf:"enabled"`
+	Enabled bool `koanf:"enabled"`
+	// File is a YAML or JSON fixture, see NewMockVaultRepositoryFromFile.
+	File     string `koanf:"file"`
 	JsonFile string `koanf:"json_file"`
 }
 
+// VaultCacheConfig controls how long each client's secrets are cached. A zero
+// TTL caches them until they are invalidated.
+//
+// Secrets are only reloaded when they are read. When a reload fails, e.g.
+// because Vault is unavailable, the last secrets loaded for the client are
+// returned instead of the error, however long ago they expired. The failure is
+// cached for ErrorTTL, so reads during an outage don't each wait for Vault.
+// Missing clients and context errors are still returned.
+type VaultCacheConfig struct {
+	TTL time.Duration `koanf:"ttl"`
+	// RefreshAhead reloads secrets in the background when they are read
+	// within RefreshAhead of their TTL.
+	RefreshAhead time.Duration `koanf:"refresh_ahead"`
+	// MaxStale is how long expired secrets are still served without waiting
+	// while they are reloaded in the background.
+	MaxStale time.Duration `koanf:"max_stale"`
+	// ErrorTTL is how long a failed load is cached before Vault is called
+	// again. It defaults to five seconds when TTL is set.
+	ErrorTTL time.Duration `koanf:"error_ttl"`
+}
+
+const defaultCacheErrorTTL = 5 * time.Second
+
+// VaultConfig authenticates with AuthMethod, which defaults to approle. The
+// fields each method requires are checked by ValidateAuth.
 type VaultConfig struct {
-	RoleId    string `koanf:"role_id" validate:"required"`
-	SecretId  string `koanf:"secret_id" validate:"required"`
-	Url       string `koanf:"url" validate:"required"`
-	MountPath string `koanf:"mount_path" validate:"required"`
-	Cert      string `koanf:"cert" validate:"required"`
-	Mock      VaultMockConfig
+	AuthMethod   VaultAuthMethod           `koanf:"auth_method" validate:"omitempty,oneof=approle kubernetes token jwt"`
+	RoleId       string                    `koanf:"role_id"`
+	SecretId     string                    `koanf:"secret_id"`
+	AppRole      VaultAppRoleAuthConfig    `koanf:"approle"`
+	Kubernetes   VaultKubernetesAuthConfig `koanf:"kubernetes"`
+	Token        VaultTokenAuthConfig      `koanf:"token"`
+	JWT          VaultJWTAuthConfig        `koanf:"jwt"`
+	Url          string                    `koanf:"url" validate:"required"`
+	MountPath    string                    `koanf:"mount_path" validate:"required"`
+	Cert         string                    `koanf:"cert" validate:"required"`
+	Mock         VaultMockConfig
+	Cache        VaultCacheConfig   `koanf:"cache"`
+	LoginBackoff VaultBackoffConfig `koanf:"login_backoff"`
+	Layers       VaultLayersConfig  `koanf:"layers"`
 }
 
+// IVaultService methods without a context use context.Background(). The
+// WithContext variants pass the context deadline down to the Vault HTTP client
+// and start a child span of it for every call.
 type IVaultService interface {
 	GetSecret(clientSlug string, key VaultSecretKey) (interface{}, error)
+	GetSecretWithContext(ctx context.Context, clientSlug string, key VaultSecretKey) (interface{}, error)
 	GetSecretAsString(clientSlug string, key VaultSecretKey) (string, error)
+	GetSecretAsStringWithContext(ctx context.Context, clientSlug string, key VaultSecretKey) (string, error)
 	GetSecrets(clientSlug string, keys []VaultSecretKey) (map[string]interface{}, error)
+	GetSecretsWithContext(ctx context.Context, clientSlug string, keys []VaultSecretKey) (map[string]interface{}, error)
+	// GetSecretSource tells whether the key of the client comes from its own
+	// secrets or from a layer, see WithSecretLayers.
+	GetSecretSource(ctx context.Context, clientSlug string, key VaultSecretKey) (SecretSource, error)
+	// ReadSecrets decodes the secrets of the client into dest, see
+	// ReadSecrets[T] for a typed variant.
 	ReadSecrets(clientSlug string, dest interface{}) error
+	ReadSecretsWithContext(ctx context.Context, clientSlug string, dest interface{}, opts ...ReadOption) error
 	List() ([]string, error)
+	ListWithContext(ctx context.Context) ([]string, error)
+	Invalidate(clientSlug string)
+	InvalidateAll()
+	// Watch polls the clients for new versions of their secrets.
+	Watch(config SecretsWatcherConfig) *SecretsWatcher
+	// VaultWriter methods invalidate the cached secrets of the client.
+	VaultWriter
+	// AuthStatus reports the state of the Vault token, e.g. for health checks.
+	AuthStatus() AuthStatus
+	Close() error
 }
 
+// VaultRepository reads client secrets from a KV v2 engine. Reads of deleted
+// or destroyed versions fail with ErrSecretVersionDeleted and
+// ErrSecretVersionDestroyed, and reads of missing clients with
+// ErrSecretNotFound.
 type VaultRepository interface {
 	GetSecrets(clientSlug string) (map[string]interface{}, error)
+	GetSecretsWithContext(ctx context.Context, clientSlug string) (map[string]interface{}, error)
+	GetSecretsVersion(ctx context.Context, clientSlug string, version int) (map[string]interface{}, error)
+	GetMetadata(ctx context.Context, clientSlug string) (*SecretMetadata, error)
 	List() ([]string, error)
+	ListWithContext(ctx context.Context) ([]string, error)
+	// ListRecursive returns the path of every secret under prefix, descending
+	// into nested folders. An empty prefix lists the whole mount.
+	ListRecursive(ctx context.Context, prefix string) ([]string, error)
+	VaultWriter
+	AuthStatus() AuthStatus
+	// Close stops the background token renewal.
+	Close() error
+}
+
+// VaultWriter writes client secrets as new KV v2 versions and returns the
+// version written.
+type VaultWriter interface {
+	// PutSecrets replaces all secrets of the client, creating it if needed.
+	PutSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error)
+	// PatchSecrets merges secrets into the current secrets of an existing
+	// client. Keys set to nil are removed.
+	PatchSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error)
+	DeleteSecretKeys(ctx context.Context, clientSlug string, keys []string, opts ...WriteOption) (int, error)
+	// DeleteClient permanently removes every version and the metadata of the
+	// client secrets.
+	DeleteClient(ctx context.Context, clientSlug string) error
 }
 
 type VaultService struct {
-	repo     VaultRepository
-	cache    typed_sync_map.TypedSyncMap[string, map[string]interface{}]
-	validate *validator.Validate
+	repo             VaultRepository
+	cache            *cache.LoadingCache[string, *clientSecrets]
+	cacheConfig      VaultCacheConfig
+	layers           VaultLayersConfig
+	validate         *validator.Validate
+	onSecretsChanged SecretsChangedHook
+	lastSecrets      typed_sync_map.TypedSyncMap[string, *clientSecrets]
+}
+
+// ClientProvider is implemented by the repository returned by
+// NewVaultRepository. Its client is authenticated and its token kept renewed,
+// so it can be shared by the engines built on top of it, such as
+// NewDatabaseCredentialsManager.
+type ClientProvider interface {
+	Client() *api.Client
 }
 
 type VaultSecretKey string
 type ClientSlug string
 
-func NewVaultService(vaultRepo VaultRepository) IVaultService {
-	return &VaultService{
+// SecretsChangedHook receives the previous and current secrets of a client when
+// a reload returns different values. It is called from the goroutine loading
+// the secrets, so it should not block.
+type SecretsChangedHook func(clientSlug string, previous map[string]interface{}, current map[string]interface{})
+
+type VaultServiceOption func(*VaultService)
+
+func WithCacheConfig(config VaultCacheConfig) VaultServiceOption {
+	return func(s *VaultService) {
+		s.cacheConfig = config
+	}
+}
+
+func WithSecretsChangedHook(hook SecretsChangedHook) VaultServiceOption {
+	return func(s *VaultService) {
+		s.onSecretsChanged = hook
+	}
+}
+
+func NewVaultService(vaultRepo VaultRepository, opts ...VaultServiceOption) IVaultService {
+	service := &VaultService{
 		repo:     vaultRepo,
 		validate: validator.New(),
 	}
+	for _, opt := range opts {
+		opt(service)
+	}
+
+	errorTTL := service.cacheConfig.ErrorTTL
+	if errorTTL == 0 && service.cacheConfig.TTL > 0 {
+		errorTTL = defaultCacheErrorTTL
+	}
+	service.cache = cache.NewLoading[string, *clientSecrets](cache.LoadingConfig{
+		TTL:          service.cacheConfig.TTL,
+		RefreshAhead: service.cacheConfig.RefreshAhead,
+		StaleTTL:     service.cacheConfig.MaxStale,
+		ErrorTTL:     errorTTL,
+	})
+	return service
 }
 
 func NewVaultServiceFromConfig(cfg VaultConfig) (IVaultService, error) {
 	if cfg.Mock.Enabled {
-		if cfg.Mock.JsonFile == "" {
-			return nil, fmt.Errorf("mocked json file name not provided")
+		fileName := cfg.Mock.File
+		if fileName == "" {
+			fileName = cfg.Mock.JsonFile
 		}
 
-		content, err := os.ReadFile(cfg.Mock.JsonFile)
+		mockRepo, err := NewMockVaultRepositoryFromFile(fileName)
 		if err != nil {
 			return nil, err
 		}
 
-		mockData := map[string]map[string]interface{}{}
-		err = json.Unmarshal(content, &mockData)
-		if err != nil {
-			return nil, err
-		}
-
-		fmt.Println(mockData)
-
-		return NewVaultService(NewMockVaultRepository(mockData)), nil
+		return NewVaultService(mockRepo, WithCacheConfig(cfg.Cache), WithSecretLayers(cfg.Layers)), nil
 	}
 
 	vaultRepo, err := NewVaultRepository(&cfg)
@@ -81,27 +210,81 @@ func NewVaultServiceFromConfig(cfg VaultConfig) (IVaultService, error) {
 		return nil, err
 	}
 
-	return NewVaultService(vaultRepo), nil
+	return NewVaultService(vaultRepo, WithCacheConfig(cfg.Cache), WithSecretLayers(cfg.Layers)), nil
 }
 
-func (s *VaultService) getClientSecrets(clientSlug string) (map[string]interface{}, error) {
-	cachedSecrets, ok := s.cache.Load(clientSlug)
-	if ok {
-		return cachedSecrets, nil
+// getClientSecrets coalesces concurrent cache misses of the same client, so a
+// burst of requests for a tenant results in a single call to Vault. The call
+// runs with the context of the first caller.
+func (s *VaultService) getClientSecrets(ctx context.Context, clientSlug string) (map[string]interface{}, error) {
+	secrets, err := s.getCachedSecrets(ctx, clientSlug)
+	if err != nil {
+		return nil, err
 	}
+	return secrets.values, nil
+}
 
-	secrets, err := s.repo.GetSecrets(clientSlug)
-	if err != nil {
+// getCachedSecrets falls back to the last secrets loaded for the client when
+// they cannot be loaded from Vault.
+func (s *VaultService) getCachedSecrets(ctx context.Context, clientSlug string) (*clientSecrets, error) {
+	secrets, err := s.cache.GetOrLoad(ctx, clientSlug, s.loadClientSecrets)
+	if err == nil || ctx.Err() != nil || errors.Is(err, ErrSecretNotFound) {
 		return secrets, err
 	}
 
-	s.cache.Store(clientSlug, secrets)
-	return secrets, nil
+	last, ok := s.lastSecrets.Load(clientSlug)
+	if !ok {
+		return nil, err
+	}
+	utils.Tracer.AddSpanEvents(trace.SpanFromContext(ctx), "Serving last secrets", map[string]string{"vault.client_slug": clientSlug, "error": err.Error()})
+	return last, nil
+}
+
+func (s *VaultService) loadClientSecrets(ctx context.Context, clientSlug string) (*clientSecrets, error) {
+	if layer, ok := s.secretLayer(clientSlug); ok {
+		return s.loadLayer(ctx, layer)
+	}
+
+	merged, err := s.mergeLayers(ctx, clientSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last secrets are kept apart from the cache so they outlive its
+	// TTL, and changes are still detected after the client is invalidated.
+	previous, ok := s.lastSecrets.Swap(clientSlug, merged)
+	if s.onSecretsChanged != nil && ok && !reflect.DeepEqual(previous.values, merged.values) {
+		s.onSecretsChanged(clientSlug, previous.values, merged.values)
+	}
+
+	return merged, nil
+}
+
+// Invalidate drops the cached secrets of the client, so the next read loads
+// them from Vault. Invalidating a layer path invalidates every client.
+func (s *VaultService) Invalidate(clientSlug string) {
+	if _, ok := s.secretLayer(clientSlug); ok {
+		s.cache.Clear()
+		return
+	}
+	s.cache.Delete(clientSlug)
+}
+
+func (s *VaultService) InvalidateAll() {
+	s.cache.Clear()
 }
 
 func (s *VaultService) GetSecret(clientSlug string, key VaultSecretKey) (interface{}, error) {
-	secrets, err := s.getClientSecrets(clientSlug)
+	return s.GetSecretWithContext(context.Background(), clientSlug, key)
+}
+
+func (s *VaultService) GetSecretWithContext(ctx context.Context, clientSlug string, key VaultSecretKey) (interface{}, error) {
+	ctx, span := newSpan(ctx, "Vault Get Secret", clientSlug)
+	defer span.End()
+
+	secrets, err := s.getClientSecrets(ctx, clientSlug)
 	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to get secret")
 		return "", err
 	}
 
@@ -110,8 +293,16 @@ func (s *VaultService) GetSecret(clientSlug string, key VaultSecretKey) (interfa
 }
 
 func (s *VaultService) GetSecretAsString(clientSlug string, key VaultSecretKey) (string, error) {
-	secrets, err := s.getClientSecrets(clientSlug)
+	return s.GetSecretAsStringWithContext(context.Background(), clientSlug, key)
+}
+
+func (s *VaultService) GetSecretAsStringWithContext(ctx context.Context, clientSlug string, key VaultSecretKey) (string, error) {
+	ctx, span := newSpan(ctx, "Vault Get Secret As String", clientSlug)
+	defer span.End()
+
+	secrets, err := s.getClientSecrets(ctx, clientSlug)
 	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to get secret")
 		return "", err
 	}
 
@@ -122,15 +313,25 @@ func (s *VaultService) GetSecretAsString(clientSlug string, key VaultSecretKey)
 
 	stringValue, ok := value.(string)
 	if !ok {
-		return "", fmt.Errorf("value is not string")
+		err := fmt.Errorf("value is not string")
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to get secret")
+		return "", err
 	}
 
 	return stringValue, nil
 }
 
 func (s *VaultService) GetSecrets(clientSlug string, keys []VaultSecretKey) (map[string]interface{}, error) {
-	secrets, err := s.getClientSecrets(clientSlug)
+	return s.GetSecretsWithContext(context.Background(), clientSlug, keys)
+}
+
+func (s *VaultService) GetSecretsWithContext(ctx context.Context, clientSlug string, keys []VaultSecretKey) (map[string]interface{}, error) {
+	ctx, span := newSpan(ctx, "Vault Get Secrets", clientSlug)
+	defer span.End()
+
+	secrets, err := s.getClientSecrets(ctx, clientSlug)
 	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to get secrets")
 		return nil, err
 	}
 
@@ -145,29 +346,106 @@ func (s *VaultService) GetSecrets(clientSlug string, keys []VaultSecretKey) (map
 }
 
 func (s *VaultService) ReadSecrets(clientSlug string, dest interface{}) error {
-	secrets, err := s.getClientSecrets(clientSlug)
+	return s.ReadSecretsWithContext(context.Background(), clientSlug, dest)
+}
+
+func (s *VaultService) ReadSecretsWithContext(ctx context.Context, clientSlug string, dest interface{}, opts ...ReadOption) error {
+	ctx, span := newSpan(ctx, "Vault Read Secrets", clientSlug)
+	defer span.End()
+
+	options := readOptions{validate: s.validate}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	err := s.readSecrets(ctx, clientSlug, dest, options)
 	if err != nil {
-		return err
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to read secrets")
 	}
+	return err
+}
 
-	k := koanf.New(".")
-	err = k.Load(confmap.Provider(secrets, ""), nil)
+func (s *VaultService) readSecrets(ctx context.Context, clientSlug string, dest interface{}, options readOptions) error {
+	secrets, err := s.getClientSecrets(ctx, clientSlug)
 	if err != nil {
 		return err
 	}
-	err = k.Unmarshal("", dest)
+
+	return decodeSecrets(clientSlug, secrets, dest, options.validate)
+}
+
+func (s *VaultService) List() ([]string, error) {
+	return s.ListWithContext(context.Background())
+}
+
+func (s *VaultService) ListWithContext(ctx context.Context) ([]string, error) {
+	ctx, span := newSpan(ctx, "Vault List", "")
+	defer span.End()
+
+	keys, err := s.repo.ListWithContext(ctx)
 	if err != nil {
-		return err
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to list clients")
 	}
+	return keys, err
+}
+
+func (s *VaultService) PutSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error) {
+	ctx, span := newSpan(ctx, "Vault Put Secrets", clientSlug)
+	defer span.End()
+
+	version, err := s.repo.PutSecrets(ctx, clientSlug, secrets, opts...)
+	return s.afterWrite(span, clientSlug, version, err)
+}
+
+func (s *VaultService) PatchSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error) {
+	ctx, span := newSpan(ctx, "Vault Patch Secrets", clientSlug)
+	defer span.End()
+
+	version, err := s.repo.PatchSecrets(ctx, clientSlug, secrets, opts...)
+	return s.afterWrite(span, clientSlug, version, err)
+}
+
+func (s *VaultService) DeleteSecretKeys(ctx context.Context, clientSlug string, keys []string, opts ...WriteOption) (int, error) {
+	ctx, span := newSpan(ctx, "Vault Delete Secret Keys", clientSlug)
+	defer span.End()
 
-	err = s.validate.Struct(dest)
+	version, err := s.repo.DeleteSecretKeys(ctx, clientSlug, keys, opts...)
+	return s.afterWrite(span, clientSlug, version, err)
+}
+
+func (s *VaultService) DeleteClient(ctx context.Context, clientSlug string) error {
+	ctx, span := newSpan(ctx, "Vault Delete Client", clientSlug)
+	defer span.End()
+
+	err := s.repo.DeleteClient(ctx, clientSlug)
+	_, err = s.afterWrite(span, clientSlug, 0, err)
+	return err
+}
+
+// afterWrite invalidates the client even if the write failed, since a write
+// that timed out may still have been applied.
+func (s *VaultService) afterWrite(span trace.Span, clientSlug string, version int, err error) (int, error) {
+	s.Invalidate(clientSlug)
 	if err != nil {
-		return err
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to write secrets")
+		return 0, err
 	}
+	return version, nil
+}
 
-	return nil
+func (s *VaultService) AuthStatus() AuthStatus {
+	return s.repo.AuthStatus()
 }
 
-func (s *VaultService) List() ([]string, error) {
-	return s.repo.List()
+func (s *VaultService) Close() error {
+	return s.repo.Close()
+}
+
+// newSpan starts a child span of ctx tagged with the client slug, if any.
+func newSpan(ctx context.Context, spanName string, clientSlug string) (context.Context, trace.Span) {
+	ctx, span := utils.Tracer.NewSpan(ctx, "vault", spanName)
+	if clientSlug != "" {
+		utils.Tracer.AddSpanTags(span, map[string]string{"vault.client_slug": clientSlug})
+	}
+	return ctx, span
 }
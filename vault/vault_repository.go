@@ -8,22 +8,50 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 
 	utils "github.com/criticalmassbr/ms-utils"
 	"github.com/hashicorp/vault/api"
-	auth "github.com/hashicorp/vault/api/auth/approle"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type vaultRepository struct {
 	config *VaultConfig
 	client *api.Client
+	auth   api.AuthMethod
+
+	// ctx is cancelled by Close to stop the token renewal.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	renewed chan struct{}
+
+	statusMu sync.RWMutex
+	status   AuthStatus
 }
 
 func NewVaultRepository(cfg *VaultConfig) (VaultRepository, error) {
+	authMethod, err := newAuthMethod(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	service := &vaultRepository{
-		config: cfg,
+		config:  cfg,
+		auth:    authMethod,
+		ctx:     ctx,
+		cancel:  cancel,
+		renewed: make(chan struct{}),
+	}
+	// The renewal only starts once init succeeds, so Close must not wait
+	// for it otherwise.
+	err = service.init()
+	if err != nil {
+		cancel()
+		close(service.renewed)
 	}
-	err := service.init()
 	return service, err
 }
 
@@ -53,23 +81,23 @@ func (c *vaultRepository) init() error {
 	}
 	c.client = client
 
-	_, err = c.login()
+	authInfo, err := c.login(c.ctx)
 	if err != nil {
 		return fmt.Errorf("unable to login to Vault: %v", err)
 	}
-	go c.renewToken()
+	c.setAuthState(AuthStateHealthy, nil, 0)
+	go c.renewToken(authInfo)
 	return nil
 }
 
-func (c *vaultRepository) login() (*api.Secret, error) {
-	appRoleAuth, err := c.newAppRoleAuth()
-	if err != nil {
-		return nil, err
-	}
+func (c *vaultRepository) Client() *api.Client {
+	return c.client
+}
 
-	authInfo, err := c.client.Auth().Login(context.Background(), appRoleAuth)
+func (c *vaultRepository) login(ctx context.Context) (*api.Secret, error) {
+	authInfo, err := c.client.Auth().Login(ctx, c.auth)
 	if err != nil {
-		return nil, fmt.Errorf("unable to login to AppRole auth method: %w", err)
+		return nil, fmt.Errorf("unable to login to %s auth method: %w", c.config.authMethod(), err)
 	}
 	if authInfo == nil {
 		return nil, fmt.Errorf("no auth info was returned after login")
@@ -78,93 +106,278 @@ func (c *vaultRepository) login() (*api.Secret, error) {
 	return authInfo, nil
 }
 
-func (c *vaultRepository) newAppRoleAuth() (*auth.AppRoleAuth, error) {
-	secretID := &auth.SecretID{FromString: c.config.SecretId}
-	appRoleAuth, err := auth.NewAppRoleAuth(
-		c.config.RoleId,
-		secretID,
-	)
+func (c *vaultRepository) GetSecrets(clientSlug string) (map[string]interface{}, error) {
+	return c.GetSecretsWithContext(context.Background(), clientSlug)
+}
+
+func (c *vaultRepository) GetSecretsWithContext(ctx context.Context, clientSlug string) (map[string]interface{}, error) {
+	ctx, span := newSpan(ctx, "Vault Repository Get Secrets", clientSlug)
+	defer span.End()
+
+	secrets, err := c.readSecrets(ctx, clientSlug, 0)
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to read secret")
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (c *vaultRepository) GetSecretsVersion(ctx context.Context, clientSlug string, version int) (map[string]interface{}, error) {
+	ctx, span := newSpan(ctx, "Vault Repository Get Secrets Version", clientSlug)
+	defer span.End()
+	utils.Tracer.AddSpanTags(span, map[string]string{"vault.secret_version": strconv.Itoa(version)})
+
+	secrets, err := c.readSecrets(ctx, clientSlug, version)
 	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to read secret")
 		return nil, err
 	}
-	return appRoleAuth, nil
+	return secrets, nil
 }
 
-func (c *vaultRepository) renewToken() {
-	for {
-		_, span := utils.Tracer.NewSpan(context.Background(), "vault", "Vault Token Renewal")
+// readSecrets reads the given version of the client secrets, or the current one
+// if version is zero.
+func (c *vaultRepository) readSecrets(ctx context.Context, clientSlug string, version int) (map[string]interface{}, error) {
+	kv := c.client.KVv2(c.config.MountPath)
+
+	var (
+		secret *api.KVSecret
+		err    error
+	)
+	if version > 0 {
+		secret, err = kv.GetVersion(ctx, clientSlug, version)
+	} else {
+		secret, err = kv.Get(ctx, clientSlug)
+	}
+	if errors.Is(err, api.ErrSecretNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret: %w", err)
+	}
 
-		authInfo, err := c.login()
+	if secret.VersionMetadata != nil {
+		err := checkSecretVersion(toSecretVersion(*secret.VersionMetadata))
 		if err != nil {
-			utils.Tracer.AddSpanErrorAndFail(span, err, "unable to authenticate to Vault")
+			return nil, fmt.Errorf("%w: %s version %d", err, clientSlug, secret.VersionMetadata.Version)
 		}
+	}
+	if secret.Data == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+	}
+
+	return secret.Data, nil
+}
+
+func (c *vaultRepository) GetMetadata(ctx context.Context, clientSlug string) (*SecretMetadata, error) {
+	ctx, span := newSpan(ctx, "Vault Repository Get Metadata", clientSlug)
+	defer span.End()
 
-		if err := c.manageTokenLifecycle(authInfo); err != nil {
-			utils.Tracer.AddSpanErrorAndFail(span, err, "unable to start managing token lifecycle")
+	metadata, err := c.client.KVv2(c.config.MountPath).GetMetadata(ctx, clientSlug)
+	if errors.Is(err, api.ErrSecretNotFound) {
+		err = fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+	} else if err != nil {
+		err = fmt.Errorf("unable to read secret metadata: %w", err)
+	}
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to read secret metadata")
+		return nil, err
+	}
+
+	result := &SecretMetadata{
+		CreatedTime:    metadata.CreatedTime,
+		UpdatedTime:    metadata.UpdatedTime,
+		CurrentVersion: metadata.CurrentVersion,
+		OldestVersion:  metadata.OldestVersion,
+		CustomMetadata: make(map[string]string, len(metadata.CustomMetadata)),
+		Versions:       make(map[int]SecretVersion, len(metadata.Versions)),
+	}
+	for key, value := range metadata.CustomMetadata {
+		result.CustomMetadata[key] = fmt.Sprintf("%v", value)
+	}
+	for key, version := range metadata.Versions {
+		number, err := strconv.Atoi(key)
+		if err != nil {
+			continue
 		}
+		version.Version = number
+		result.Versions[number] = toSecretVersion(version)
+	}
 
-		span.End()
+	return result, nil
+}
+
+func toSecretVersion(version api.KVVersionMetadata) SecretVersion {
+	return SecretVersion{
+		Version:      version.Version,
+		CreatedTime:  version.CreatedTime,
+		DeletionTime: version.DeletionTime,
+		Destroyed:    version.Destroyed,
 	}
 }
 
-func (c *vaultRepository) manageTokenLifecycle(token *api.Secret) error {
-	if !token.Auth.Renewable {
-		_, span := utils.Tracer.NewSpan(context.Background(), "vault", "Vault Token Renewal")
-		utils.Tracer.AddSpanEvents(span, "Token not renewable", map[string]string{"message": "Token is not configured to be renewable. Re-attempting login."})
-		span.End()
-		return nil
+func (c *vaultRepository) PutSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error) {
+	ctx, span := newSpan(ctx, "Vault Repository Put Secrets", clientSlug)
+	defer span.End()
+
+	secret, err := c.client.KVv2(c.config.MountPath).Put(ctx, clientSlug, secrets, toKVOptions(opts)...)
+	return writtenVersion(span, clientSlug, secret, err)
+}
+
+func (c *vaultRepository) PatchSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error) {
+	ctx, span := newSpan(ctx, "Vault Repository Patch Secrets", clientSlug)
+	defer span.End()
+
+	secret, err := c.client.KVv2(c.config.MountPath).Patch(ctx, clientSlug, secrets, toKVOptions(opts)...)
+	return writtenVersion(span, clientSlug, secret, err)
+}
+
+// DeleteSecretKeys writes the current secrets without the keys. Unless a
+// check-and-set version is given, the write is checked against the version
+// read, so concurrent updates are never lost.
+func (c *vaultRepository) DeleteSecretKeys(ctx context.Context, clientSlug string, keys []string, opts ...WriteOption) (int, error) {
+	ctx, span := newSpan(ctx, "Vault Repository Delete Secret Keys", clientSlug)
+	defer span.End()
+
+	kv := c.client.KVv2(c.config.MountPath)
+	current, err := kv.Get(ctx, clientSlug)
+	if err == nil && (current.Data == nil || current.VersionMetadata == nil) {
+		err = api.ErrSecretNotFound
+	}
+	if err != nil {
+		return writtenVersion(span, clientSlug, nil, err)
+	}
+
+	secrets := make(map[string]interface{}, len(current.Data))
+	for key, value := range current.Data {
+		secrets[key] = value
+	}
+	for _, key := range keys {
+		delete(secrets, key)
+	}
+
+	if newWriteOptions(opts).checkAndSet == nil {
+		opts = append(opts, WithCheckAndSet(current.VersionMetadata.Version))
 	}
+	secret, err := kv.Put(ctx, clientSlug, secrets, toKVOptions(opts)...)
+	return writtenVersion(span, clientSlug, secret, err)
+}
+
+func (c *vaultRepository) DeleteClient(ctx context.Context, clientSlug string) error {
+	ctx, span := newSpan(ctx, "Vault Repository Delete Client", clientSlug)
+	defer span.End()
 
-	watcher, err := c.client.NewLifetimeWatcher(&api.LifetimeWatcherInput{
-		Secret:    token,
-		Increment: 3600,
-	})
+	err := c.client.KVv2(c.config.MountPath).DeleteMetadata(ctx, clientSlug)
 	if err != nil {
-		return fmt.Errorf("unable to initialize new lifetime watcher for renewing auth token: %w", err)
+		err = fmt.Errorf("unable to delete secret: %w", err)
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to delete secret")
 	}
+	return err
+}
 
-	go watcher.Start()
-	defer watcher.Stop()
+func toKVOptions(opts []WriteOption) []api.KVOption {
+	options := newWriteOptions(opts)
+	kvOptions := make([]api.KVOption, 0)
+	if options.checkAndSet != nil {
+		kvOptions = append(kvOptions, api.WithCheckAndSet(*options.checkAndSet))
+	}
+	return kvOptions
+}
 
-	for {
-		_, span := utils.Tracer.NewSpan(context.Background(), "vault", "Vault Token Renewal")
+// writtenVersion translates the result of a write into the version written or
+// a typed error.
+func writtenVersion(span trace.Span, clientSlug string, secret *api.KVSecret, err error) (int, error) {
+	var responseErr *api.ResponseError
+	switch {
+	case err == nil && (secret == nil || secret.VersionMetadata == nil):
+		err = fmt.Errorf("no version was returned after writing secret")
+	case errors.As(err, &responseErr) && isCheckAndSetMismatch(responseErr):
+		err = fmt.Errorf("%w: %s", ErrCheckAndSetMismatch, clientSlug)
+	case errors.Is(err, api.ErrSecretNotFound):
+		err = fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+	case err != nil:
+		err = fmt.Errorf("unable to write secret: %w", err)
+	}
 
-		select {
-		case err := <-watcher.DoneCh():
-			if err != nil {
-				utils.Tracer.AddSpanEvents(span, "Failed to renew token", map[string]string{"message": fmt.Sprintf("Failed to renew token: %v. Re-attempting login.", err)})
-				span.End()
-				return nil
-			}
-			utils.Tracer.AddSpanEvents(span, "Failed to renew token", map[string]string{"message": "Token can no longer be renewed. Re-attempting login."})
-			span.End()
-			return nil
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to write secret")
+		return 0, err
+	}
+	return secret.VersionMetadata.Version, nil
+}
 
-		case renewal := <-watcher.RenewCh():
-			utils.Tracer.AddSpanEvents(span, "Token renewed", map[string]string{"message": fmt.Sprintf("Successfully renewed: %#v", renewal)})
+func isCheckAndSetMismatch(err *api.ResponseError) bool {
+	if err.StatusCode != http.StatusBadRequest {
+		return false
+	}
+	for _, message := range err.Errors {
+		if strings.Contains(message, "check-and-set parameter did not match") {
+			return true
 		}
-		span.End()
 	}
+	return false
 }
 
-func (c *vaultRepository) GetSecrets(clientSlug string) (map[string]interface{}, error) {
-	secret, err := c.client.KVv1(fmt.Sprintf("%s/data", c.config.MountPath)).Get(context.Background(), clientSlug)
+func (c *vaultRepository) List() ([]string, error) {
+	return c.ListWithContext(context.Background())
+}
+
+func (c *vaultRepository) ListWithContext(ctx context.Context) ([]string, error) {
+	ctx, span := newSpan(ctx, "Vault Repository List", "")
+	defer span.End()
+
+	keys, err := c.list(ctx, "")
 	if err != nil {
-		return nil, fmt.Errorf("unable to read secret: %v", err)
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to list secrets")
 	}
+	return keys, err
+}
 
-	secrets, ok := secret.Data["data"].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("secret value type assertion failed")
+func (c *vaultRepository) ListRecursive(ctx context.Context, prefix string) ([]string, error) {
+	ctx, span := newSpan(ctx, "Vault Repository List Recursive", "")
+	defer span.End()
+
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
 	}
 
-	return secrets, nil
+	paths, err := c.listRecursive(ctx, prefix)
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to list secrets")
+	}
+	return paths, err
 }
 
-func (c *vaultRepository) List() ([]string, error) {
-	secrets, err := c.client.Logical().List(fmt.Sprintf("%s/metadata", c.config.MountPath))
+func (c *vaultRepository) listRecursive(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := c.list(ctx, prefix)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read secret: %v", err)
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") {
+			paths = append(paths, prefix+key)
+			continue
+		}
+
+		nested, err := c.listRecursive(ctx, prefix+key)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, nested...)
+	}
+	return paths, nil
+}
+
+// list returns the keys directly under path. Folders end with a slash.
+func (c *vaultRepository) list(ctx context.Context, path string) ([]string, error) {
+	secrets, err := c.client.Logical().ListWithContext(ctx, fmt.Sprintf("%s/metadata/%s", c.config.MountPath, path))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read secret: %w", err)
+	}
+	if secrets == nil {
+		return []string{}, nil
 	}
 
 	if secrets.Data == nil {
@@ -1,29 +1,88 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/koanf/parsers/yaml"
 )
 
+// AnyClient matches the calls of every client in SetFault, including the
+// calls that are not about a client, like List.
+const AnyClient = "*"
+
 type VaultMockData map[string]map[string]interface{}
 
+// MockFault makes the calls of a client wait for Latency and then fail with
+// Err, if set. Latency is cut short when the context of the call is done.
+type MockFault struct {
+	Err     error
+	Latency time.Duration
+	// Times the fault applies before it is cleared, 0 meaning always.
+	Times int
+}
+
+// MockCall is a call made to the mock repository. Args holds the arguments
+// after the context and client slug, if any.
+type MockCall struct {
+	Method     string
+	ClientSlug string
+	Args       []interface{}
+	Time       time.Time
+}
+
+// MockTestingT is implemented by *testing.T.
+type MockTestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// vaultMockRepository keeps every version of the client secrets in memory, like
+// a KV v2 engine. The mock data is stored as version 1 of each client. It is
+// safe for concurrent use.
 type vaultMockRepository struct {
-	mockData      VaultMockData
-	numberOfCalls map[string]int
+	secrets   map[string]*mockSecret
+	faults    map[string]MockFault
+	calls     []MockCall
+	createdAt time.Time
+	mu        sync.Mutex
+}
+
+type mockSecret struct {
+	metadata SecretMetadata
+	data     map[int]map[string]interface{}
 }
 
 func NewMockVaultRepository(mockData VaultMockData) *vaultMockRepository {
 	service := &vaultMockRepository{
-		mockData:      mockData,
-		numberOfCalls: map[string]int{},
+		secrets:   map[string]*mockSecret{},
+		faults:    map[string]MockFault{},
+		createdAt: time.Now(),
+	}
+	for clientSlug, secrets := range mockData {
+		service.addVersion(clientSlug, secrets)
 	}
 	return service
 }
 
 func NewMockVaultRepositoryFromJsonFile(fileName string) (*vaultMockRepository, error) {
+	return NewMockVaultRepositoryFromFile(fileName)
+}
+
+// NewMockVaultRepositoryFromFile reads the mock data from a YAML file, if its
+// extension is .yaml or .yml, or from a JSON file. Both map client slugs to
+// their secrets.
+func NewMockVaultRepositoryFromFile(fileName string) (*vaultMockRepository, error) {
 	if fileName == "" {
-		return nil, fmt.Errorf("mocked json file name not provided")
+		return nil, fmt.Errorf("mocked file name not provided")
 	}
 
 	content, err := os.ReadFile(fileName)
@@ -31,41 +90,448 @@ func NewMockVaultRepositoryFromJsonFile(fileName string) (*vaultMockRepository,
 		return nil, err
 	}
 
-	mockData := map[string]map[string]interface{}{}
-	err = json.Unmarshal(content, &mockData)
-	if err != nil {
-		return nil, err
+	mockData := VaultMockData{}
+	switch filepath.Ext(fileName) {
+	case ".yaml", ".yml":
+		parsed, err := yaml.Parser().Unmarshal(content)
+		if err != nil {
+			return nil, err
+		}
+		for clientSlug, secrets := range parsed {
+			secretsMap, ok := secrets.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("mocked secrets of %s are not a map", clientSlug)
+			}
+			mockData[clientSlug] = secretsMap
+		}
+	default:
+		if err := json.Unmarshal(content, &mockData); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewMockVaultRepository(mockData), nil
+}
+
+// SetFault applies fault to the following calls of the client, or of every
+// client with AnyClient. Faults of a client take precedence over AnyClient.
+func (s *vaultMockRepository) SetFault(clientSlug string, fault MockFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults[clientSlug] = fault
+}
+
+func (s *vaultMockRepository) ClearFaults() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = map[string]MockFault{}
+}
+
+// call records the call and applies the fault of the client, if any.
+func (s *vaultMockRepository) call(ctx context.Context, method string, clientSlug string, args ...interface{}) error {
+	s.mu.Lock()
+	s.calls = append(s.calls, MockCall{Method: method, ClientSlug: clientSlug, Args: args, Time: time.Now()})
+
+	faultKey := clientSlug
+	fault, ok := s.faults[faultKey]
+	if !ok {
+		faultKey = AnyClient
+		fault, ok = s.faults[faultKey]
+	}
+	if ok && fault.Times > 0 {
+		remaining := fault
+		remaining.Times--
+		if remaining.Times == 0 {
+			delete(s.faults, faultKey)
+		} else {
+			s.faults[faultKey] = remaining
+		}
+	}
+	s.mu.Unlock()
+
+	if fault.Latency > 0 && !sleep(ctx, fault.Latency) {
+		return ctx.Err()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	return fault.Err
+}
 
-	return &vaultMockRepository{
-		mockData:      mockData,
-		numberOfCalls: map[string]int{},
-	}, nil
+// Calls returns the calls made so far, in order.
+func (s *vaultMockRepository) Calls() []MockCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]MockCall{}, s.calls...)
+}
+
+// CallsTo returns the calls made to method for the client, or for every
+// client with AnyClient.
+func (s *vaultMockRepository) CallsTo(method string, clientSlug string) []MockCall {
+	var calls []MockCall
+	for _, call := range s.Calls() {
+		if call.Method == method && (clientSlug == AnyClient || call.ClientSlug == clientSlug) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (s *vaultMockRepository) ResetCalls() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = nil
+}
+
+// AssertCalled checks method was called for the client, with args if given.
+func (s *vaultMockRepository) AssertCalled(t MockTestingT, method string, clientSlug string, args ...interface{}) bool {
+	t.Helper()
+	for _, call := range s.CallsTo(method, clientSlug) {
+		if len(args) == 0 || reflect.DeepEqual(call.Args, args) {
+			return true
+		}
+	}
+	if len(args) == 0 {
+		t.Errorf("expected %s to be called for %q, calls were: %v", method, clientSlug, s.Calls())
+	} else {
+		t.Errorf("expected %s to be called for %q with %v, calls were: %v", method, clientSlug, args, s.Calls())
+	}
+	return false
+}
+
+func (s *vaultMockRepository) AssertNotCalled(t MockTestingT, method string, clientSlug string) bool {
+	t.Helper()
+	if calls := s.CallsTo(method, clientSlug); len(calls) > 0 {
+		t.Errorf("expected %s not to be called for %q, but it was called %d times", method, clientSlug, len(calls))
+		return false
+	}
+	return true
+}
+
+func (s *vaultMockRepository) AssertNumberOfCalls(t MockTestingT, method string, clientSlug string, expected int) bool {
+	t.Helper()
+	if calls := s.CallsTo(method, clientSlug); len(calls) != expected {
+		t.Errorf("expected %s to be called %d times for %q, but it was called %d times", method, expected, clientSlug, len(calls))
+		return false
+	}
+	return true
+}
+
+// addVersion stores secrets as the next version of the client. It must be
+// called with the lock held.
+func (s *vaultMockRepository) addVersion(clientSlug string, secrets map[string]interface{}) int {
+	now := time.Now()
+	secret, ok := s.secrets[clientSlug]
+	if !ok {
+		secret = &mockSecret{
+			metadata: SecretMetadata{
+				CreatedTime:    now,
+				CustomMetadata: map[string]string{},
+				Versions:       map[int]SecretVersion{},
+			},
+			data: map[int]map[string]interface{}{},
+		}
+		s.secrets[clientSlug] = secret
+	}
+
+	version := secret.metadata.CurrentVersion + 1
+	secret.metadata.CurrentVersion = version
+	secret.metadata.UpdatedTime = now
+	if secret.metadata.OldestVersion == 0 {
+		secret.metadata.OldestVersion = version
+	}
+	secret.metadata.Versions[version] = SecretVersion{Version: version, CreatedTime: now}
+	secret.data[version] = secrets
+	return version
 }
 
 func (s *vaultMockRepository) GetSecrets(clientSlug string) (map[string]interface{}, error) {
-	n, _ := s.numberOfCalls[clientSlug]
-	s.numberOfCalls[clientSlug] = n + 1
+	if err := s.call(context.Background(), "GetSecrets", clientSlug); err != nil {
+		return nil, err
+	}
+	return s.getSecretsVersion(clientSlug, 0)
+}
 
-	clientSecrets, ok := s.mockData[clientSlug]
+// GetSecretsWithContext fails with the context error once the context is done,
+// like the Vault client does.
+func (s *vaultMockRepository) GetSecretsWithContext(ctx context.Context, clientSlug string) (map[string]interface{}, error) {
+	if err := s.call(ctx, "GetSecretsWithContext", clientSlug); err != nil {
+		return nil, err
+	}
+	return s.getSecretsVersion(clientSlug, 0)
+}
+
+func (s *vaultMockRepository) GetSecretsVersion(ctx context.Context, clientSlug string, version int) (map[string]interface{}, error) {
+	if err := s.call(ctx, "GetSecretsVersion", clientSlug, version); err != nil {
+		return nil, err
+	}
+	return s.getSecretsVersion(clientSlug, version)
+}
+
+func (s *vaultMockRepository) getSecretsVersion(clientSlug string, version int) (map[string]interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secret, ok := s.secrets[clientSlug]
 	if !ok {
-		return nil, fmt.Errorf("No secrets for client %s", clientSlug)
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+	}
+	if version == 0 {
+		version = secret.metadata.CurrentVersion
+	}
+
+	versionMetadata, ok := secret.metadata.Versions[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s version %d", ErrSecretNotFound, clientSlug, version)
+	}
+	if err := checkSecretVersion(versionMetadata); err != nil {
+		return nil, fmt.Errorf("%w: %s version %d", err, clientSlug, version)
 	}
 
-	return clientSecrets, nil
+	return secret.data[version], nil
 }
 
+func (s *vaultMockRepository) GetMetadata(ctx context.Context, clientSlug string) (*SecretMetadata, error) {
+	if err := s.call(ctx, "GetMetadata", clientSlug); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secret, ok := s.secrets[clientSlug]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+	}
+
+	metadata := secret.metadata
+	metadata.CustomMetadata = make(map[string]string, len(secret.metadata.CustomMetadata))
+	for key, value := range secret.metadata.CustomMetadata {
+		metadata.CustomMetadata[key] = value
+	}
+	metadata.Versions = make(map[int]SecretVersion, len(secret.metadata.Versions))
+	for key, value := range secret.metadata.Versions {
+		metadata.Versions[key] = value
+	}
+	return &metadata, nil
+}
+
+func (s *vaultMockRepository) PutSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error) {
+	if err := s.call(ctx, "PutSecrets", clientSlug, secrets); err != nil {
+		return 0, err
+	}
+	return s.write(clientSlug, opts, func(current map[string]interface{}, exists bool) (map[string]interface{}, error) {
+		return copySecrets(secrets), nil
+	})
+}
+
+func (s *vaultMockRepository) PatchSecrets(ctx context.Context, clientSlug string, secrets map[string]interface{}, opts ...WriteOption) (int, error) {
+	if err := s.call(ctx, "PatchSecrets", clientSlug, secrets); err != nil {
+		return 0, err
+	}
+	return s.write(clientSlug, opts, func(current map[string]interface{}, exists bool) (map[string]interface{}, error) {
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+		}
+		patched := copySecrets(current)
+		for key, value := range secrets {
+			if value == nil {
+				delete(patched, key)
+				continue
+			}
+			patched[key] = value
+		}
+		return patched, nil
+	})
+}
+
+func (s *vaultMockRepository) DeleteSecretKeys(ctx context.Context, clientSlug string, keys []string, opts ...WriteOption) (int, error) {
+	if err := s.call(ctx, "DeleteSecretKeys", clientSlug, keys); err != nil {
+		return 0, err
+	}
+	return s.write(clientSlug, opts, func(current map[string]interface{}, exists bool) (map[string]interface{}, error) {
+		if !exists {
+			return nil, fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+		}
+		remaining := copySecrets(current)
+		for _, key := range keys {
+			delete(remaining, key)
+		}
+		return remaining, nil
+	})
+}
+
+func (s *vaultMockRepository) DeleteClient(ctx context.Context, clientSlug string) error {
+	if err := s.call(ctx, "DeleteClient", clientSlug); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.secrets, clientSlug)
+	return nil
+}
+
+// write checks the check-and-set version against the current version and stores
+// the secrets returned by update as a new version. current holds the secrets of
+// the current version, and exists is false if they are missing or deleted.
+func (s *vaultMockRepository) write(clientSlug string, opts []WriteOption, update func(current map[string]interface{}, exists bool) (map[string]interface{}, error)) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		currentVersion int
+		current        map[string]interface{}
+		exists         bool
+	)
+	if secret, ok := s.secrets[clientSlug]; ok {
+		currentVersion = secret.metadata.CurrentVersion
+		if checkSecretVersion(secret.metadata.Versions[currentVersion]) == nil {
+			current, exists = secret.data[currentVersion], true
+		}
+	}
+
+	options := newWriteOptions(opts)
+	if options.checkAndSet != nil && *options.checkAndSet != currentVersion {
+		return 0, fmt.Errorf("%w: %s", ErrCheckAndSetMismatch, clientSlug)
+	}
+
+	secrets, err := update(current, exists)
+	if err != nil {
+		return 0, err
+	}
+	return s.addVersion(clientSlug, secrets), nil
+}
+
+func copySecrets(secrets map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(secrets))
+	for key, value := range secrets {
+		copied[key] = value
+	}
+	return copied
+}
+
+// SetCustomMetadata replaces the custom metadata of the client.
+func (s *vaultMockRepository) SetCustomMetadata(clientSlug string, customMetadata map[string]string) error {
+	return s.updateSecret(clientSlug, func(secret *mockSecret) {
+		secret.metadata.CustomMetadata = customMetadata
+	})
+}
+
+// DeleteVersions marks versions of the client as deleted, like the KV v2
+// delete operation.
+func (s *vaultMockRepository) DeleteVersions(clientSlug string, versions ...int) error {
+	return s.updateVersions(clientSlug, versions, func(version *SecretVersion) {
+		version.DeletionTime = time.Now()
+	})
+}
+
+// DestroyVersions permanently removes the data of versions of the client.
+func (s *vaultMockRepository) DestroyVersions(clientSlug string, versions ...int) error {
+	return s.updateVersions(clientSlug, versions, func(version *SecretVersion) {
+		version.Destroyed = true
+	})
+}
+
+func (s *vaultMockRepository) updateVersions(clientSlug string, versions []int, update func(version *SecretVersion)) error {
+	return s.updateSecret(clientSlug, func(secret *mockSecret) {
+		for _, number := range versions {
+			version, ok := secret.metadata.Versions[number]
+			if !ok {
+				continue
+			}
+			update(&version)
+			secret.metadata.Versions[number] = version
+			if version.Destroyed {
+				delete(secret.data, number)
+			}
+		}
+	})
+}
+
+func (s *vaultMockRepository) updateSecret(clientSlug string, update func(secret *mockSecret)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	secret, ok := s.secrets[clientSlug]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSecretNotFound, clientSlug)
+	}
+	update(secret)
+	return nil
+}
+
+// AuthStatus is always healthy, since the mock does not authenticate.
+func (s *vaultMockRepository) AuthStatus() AuthStatus {
+	return AuthStatus{State: AuthStateHealthy, Since: s.createdAt}
+}
+
+func (s *vaultMockRepository) Close() error {
+	return nil
+}
+
+// NumberOfCalls counts the reads of the secrets of the client.
 func (s *vaultMockRepository) NumberOfCalls(clientSlug string) int {
-	n, _ := s.numberOfCalls[clientSlug]
-	return n
+	return len(s.CallsTo("GetSecrets", clientSlug)) +
+		len(s.CallsTo("GetSecretsWithContext", clientSlug)) +
+		len(s.CallsTo("GetSecretsVersion", clientSlug))
 }
 
 func (s *vaultMockRepository) List() ([]string, error) {
-	keys := make([]string, 0)
+	if err := s.call(context.Background(), "List", ""); err != nil {
+		return nil, err
+	}
+	return s.list()
+}
 
-	for key := range s.mockData {
-		keys = append(keys, key)
+// ListWithContext returns the keys at the top level of the mock data, with
+// nested paths collapsed into folders ending with a slash.
+func (s *vaultMockRepository) ListWithContext(ctx context.Context) ([]string, error) {
+	if err := s.call(ctx, "ListWithContext", ""); err != nil {
+		return nil, err
+	}
+	return s.list()
+}
+
+func (s *vaultMockRepository) list() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen := map[string]struct{}{}
+	keys := make([]string, 0)
+	for clientSlug := range s.secrets {
+		key := clientSlug
+		if i := strings.Index(clientSlug, "/"); i >= 0 {
+			key = clientSlug[:i+1]
+		}
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			keys = append(keys, key)
+		}
 	}
 
 	return keys, nil
 }
+
+func (s *vaultMockRepository) ListRecursive(ctx context.Context, prefix string) ([]string, error) {
+	if err := s.call(ctx, "ListRecursive", "", prefix); err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	paths := make([]string, 0)
+	for clientSlug := range s.secrets {
+		if strings.HasPrefix(clientSlug, prefix) {
+			paths = append(paths, clientSlug)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
+}
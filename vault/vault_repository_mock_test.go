@@ -0,0 +1,297 @@
+package vault_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMockVaultRepository(t *testing.T) {
+	ctx := context.Background()
+	newRepo := func() vault.VaultRepository {
+		return vault.NewMockVaultRepository(vault.VaultMockData{
+			"client1":         {"VAR": "value"},
+			"group/client2":   {"VAR": "value 2"},
+			"group/a/client3": {"VAR": "value 3"},
+		})
+	}
+
+	t.Run("GetSecretsVersion should read the requested version", func(t *testing.T) {
+		repo := newRepo()
+
+		secrets, err := repo.GetSecretsVersion(ctx, "client1", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"VAR": "value"}, secrets)
+
+		_, err = repo.GetSecretsVersion(ctx, "client1", 2)
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+
+		_, err = repo.GetSecrets("missing")
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+	})
+
+	t.Run("deleted and destroyed versions should return typed errors", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value"}})
+
+		assert.NoError(t, repo.DeleteVersions("client1", 1))
+		_, err := repo.GetSecrets("client1")
+		assert.ErrorIs(t, err, vault.ErrSecretVersionDeleted)
+
+		assert.NoError(t, repo.DestroyVersions("client1", 1))
+		_, err = repo.GetSecretsVersion(ctx, "client1", 1)
+		assert.ErrorIs(t, err, vault.ErrSecretVersionDestroyed)
+
+		assert.ErrorIs(t, repo.DeleteVersions("missing", 1), vault.ErrSecretNotFound)
+	})
+
+	t.Run("GetMetadata should describe the versions", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value"}})
+		assert.NoError(t, repo.SetCustomMetadata("client1", map[string]string{"owner": "team"}))
+		assert.NoError(t, repo.DeleteVersions("client1", 1))
+
+		metadata, err := repo.GetMetadata(ctx, "client1")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, metadata.CurrentVersion)
+		assert.Equal(t, 1, metadata.OldestVersion)
+		assert.Equal(t, map[string]string{"owner": "team"}, metadata.CustomMetadata)
+		assert.False(t, metadata.CreatedTime.IsZero())
+		assert.False(t, metadata.UpdatedTime.IsZero())
+		assert.False(t, metadata.Versions[1].DeletionTime.IsZero())
+
+		_, err = repo.GetMetadata(ctx, "missing")
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+	})
+
+	t.Run("List should collapse nested paths into folders", func(t *testing.T) {
+		keys, err := newRepo().List()
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"client1", "group/"}, keys)
+	})
+
+	t.Run("ListRecursive should return nested paths", func(t *testing.T) {
+		repo := newRepo()
+
+		paths, err := repo.ListRecursive(ctx, "")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"client1", "group/a/client3", "group/client2"}, paths)
+
+		paths, err = repo.ListRecursive(ctx, "group/a")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"group/a/client3"}, paths)
+	})
+
+	t.Run("PutSecrets should write a new version", func(t *testing.T) {
+		repo := newRepo()
+
+		version, err := repo.PutSecrets(ctx, "client1", map[string]interface{}{"OTHER": "other"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+
+		secrets, err := repo.GetSecrets("client1")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"OTHER": "other"}, secrets)
+
+		secrets, err = repo.GetSecretsVersion(ctx, "client1", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"VAR": "value"}, secrets)
+	})
+
+	t.Run("PutSecrets should respect check-and-set", func(t *testing.T) {
+		repo := newRepo()
+
+		_, err := repo.PutSecrets(ctx, "client1", map[string]interface{}{}, vault.WithCheckAndSet(0))
+		assert.ErrorIs(t, err, vault.ErrCheckAndSetMismatch)
+
+		version, err := repo.PutSecrets(ctx, "client1", map[string]interface{}{}, vault.WithCheckAndSet(1))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+
+		_, err = repo.PutSecrets(ctx, "client1", map[string]interface{}{}, vault.WithCheckAndSet(1))
+		assert.ErrorIs(t, err, vault.ErrCheckAndSetMismatch)
+
+		version, err = repo.PutSecrets(ctx, "new", map[string]interface{}{"VAR": "new"}, vault.WithCheckAndSet(0))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, version)
+	})
+
+	t.Run("PatchSecrets should merge keys", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value", "OLD": "old"}})
+
+		version, err := repo.PatchSecrets(ctx, "client1", map[string]interface{}{"NEW": "new", "OLD": nil}, vault.WithCheckAndSet(1))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+
+		secrets, err := repo.GetSecrets("client1")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"VAR": "value", "NEW": "new"}, secrets)
+
+		_, err = repo.PatchSecrets(ctx, "missing", map[string]interface{}{"NEW": "new"})
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+	})
+
+	t.Run("DeleteSecretKeys should remove keys", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value", "OLD": "old"}})
+
+		_, err := repo.DeleteSecretKeys(ctx, "client1", []string{"OLD"}, vault.WithCheckAndSet(2))
+		assert.ErrorIs(t, err, vault.ErrCheckAndSetMismatch)
+
+		version, err := repo.DeleteSecretKeys(ctx, "client1", []string{"OLD", "MISSING"})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+
+		secrets, err := repo.GetSecrets("client1")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"VAR": "value"}, secrets)
+	})
+
+	t.Run("DeleteClient should remove every version", func(t *testing.T) {
+		repo := newRepo()
+
+		assert.NoError(t, repo.DeleteClient(ctx, "client1"))
+
+		_, err := repo.GetSecretsVersion(ctx, "client1", 1)
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+		_, err = repo.GetMetadata(ctx, "client1")
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+
+		version, err := repo.PutSecrets(ctx, "client1", map[string]interface{}{}, vault.WithCheckAndSet(0))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, version)
+	})
+}
+
+type recordingT struct {
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestMockVaultRepositoryFaults(t *testing.T) {
+	ctx := context.Background()
+	errVaultDown := errors.New("vault is down")
+
+	t.Run("fixtures should be read from YAML and JSON files", func(t *testing.T) {
+		dir := t.TempDir()
+		yamlFile := filepath.Join(dir, "secrets.yaml")
+		jsonFile := filepath.Join(dir, "secrets.json")
+		assert.NoError(t, os.WriteFile(yamlFile, []byte("client1:\n  VAR: value\n  PORT: 587\n"), 0o600))
+		assert.NoError(t, os.WriteFile(jsonFile, []byte(`{"client1": {"VAR": "value", "PORT": 587}}`), 0o600))
+
+		for _, fileName := range []string{yamlFile, jsonFile} {
+			repo, err := vault.NewMockVaultRepositoryFromFile(fileName)
+			assert.NoError(t, err)
+			secrets, err := repo.GetSecrets("client1")
+			assert.NoError(t, err)
+			assert.Equal(t, "value", secrets["VAR"])
+			assert.EqualValues(t, 587, secrets["PORT"])
+		}
+
+		assert.NoError(t, os.WriteFile(yamlFile, []byte("client1: value\n"), 0o600))
+		_, err := vault.NewMockVaultRepositoryFromFile(yamlFile)
+		assert.Error(t, err)
+	})
+
+	t.Run("faults should fail the calls of the client", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value"}, "client2": {"VAR": "value"}})
+		repo.SetFault("client1", vault.MockFault{Err: errVaultDown})
+
+		_, err := repo.GetSecrets("client1")
+		assert.ErrorIs(t, err, errVaultDown)
+		_, err = repo.PutSecrets(ctx, "client1", map[string]interface{}{})
+		assert.ErrorIs(t, err, errVaultDown)
+		_, err = repo.GetSecrets("client2")
+		assert.NoError(t, err)
+
+		repo.ClearFaults()
+		_, err = repo.GetSecrets("client1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("faults should apply the given number of times", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value"}})
+		repo.SetFault(vault.AnyClient, vault.MockFault{Err: errVaultDown, Times: 2})
+
+		_, err := repo.List()
+		assert.ErrorIs(t, err, errVaultDown)
+		_, err = repo.GetSecrets("client1")
+		assert.ErrorIs(t, err, errVaultDown)
+		_, err = repo.GetSecrets("client1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("latency should be cut short by the context", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value"}})
+		repo.SetFault("client1", vault.MockFault{Latency: 20 * time.Millisecond})
+
+		start := time.Now()
+		_, err := repo.GetSecretsWithContext(ctx, "client1")
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+		repo.SetFault("client1", vault.MockFault{Latency: time.Minute})
+		timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, err = repo.GetSecretsWithContext(timeoutCtx, "client1")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+
+	t.Run("calls should be recorded with their arguments", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value"}})
+
+		repo.GetSecretsVersion(ctx, "client1", 1)
+		repo.PatchSecrets(ctx, "client1", map[string]interface{}{"VAR": "patched"})
+		repo.ListRecursive(ctx, "group")
+
+		calls := repo.Calls()
+		assert.Len(t, calls, 3)
+		assert.Equal(t, "GetSecretsVersion", calls[0].Method)
+		assert.Equal(t, []interface{}{1}, calls[0].Args)
+		assert.Equal(t, []interface{}{"group"}, calls[2].Args)
+
+		assert.True(t, repo.AssertCalled(t, "PatchSecrets", "client1", map[string]interface{}{"VAR": "patched"}))
+		assert.True(t, repo.AssertNotCalled(t, "DeleteClient", vault.AnyClient))
+		assert.True(t, repo.AssertNumberOfCalls(t, "GetSecretsVersion", "client1", 1))
+
+		failing := &recordingT{}
+		assert.False(t, repo.AssertCalled(failing, "PatchSecrets", "client1", map[string]interface{}{"VAR": "other"}))
+		assert.False(t, repo.AssertNotCalled(failing, "PatchSecrets", "client1"))
+		assert.False(t, repo.AssertNumberOfCalls(failing, "GetSecretsVersion", "client1", 2))
+		assert.Len(t, failing.errors, 3)
+
+		repo.ResetCalls()
+		assert.Empty(t, repo.Calls())
+	})
+
+	t.Run("concurrent calls should be safe", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{"client1": {"VAR": "value"}})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				repo.GetSecrets("client1")
+				repo.PatchSecrets(ctx, "client1", map[string]interface{}{"VAR": i})
+				repo.SetFault("client2", vault.MockFault{Times: 1})
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, 50, repo.NumberOfCalls("client1"))
+		metadata, err := repo.GetMetadata(ctx, "client1")
+		assert.NoError(t, err)
+		assert.Equal(t, 51, metadata.CurrentVersion)
+	})
+}
@@ -1,10 +1,20 @@
 package vault_test
 
 import (
+	"context"
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
+	utils "github.com/criticalmassbr/ms-utils"
 	"github.com/criticalmassbr/ms-utils/vault"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 const (
@@ -16,6 +26,51 @@ const (
 	OTHER_VAR vault.VaultSecretKey = "OTHER_VAR"
 )
 
+// switchableRepository returns the secrets last set for every client, or err.
+// Methods it does not override panic.
+type switchableRepository struct {
+	vault.VaultRepository
+	mu      sync.Mutex
+	secrets map[string]interface{}
+	err     error
+	calls   int
+}
+
+func (r *switchableRepository) GetSecrets(clientSlug string) (map[string]interface{}, error) {
+	return r.GetSecretsWithContext(context.Background(), clientSlug)
+}
+
+func (r *switchableRepository) GetSecretsWithContext(ctx context.Context, clientSlug string) (map[string]interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	return r.secrets, nil
+}
+
+func (r *switchableRepository) List() ([]string, error) {
+	return r.ListWithContext(context.Background())
+}
+
+func (r *switchableRepository) ListWithContext(ctx context.Context) ([]string, error) {
+	return []string{}, nil
+}
+
+func (r *switchableRepository) Set(secrets map[string]interface{}, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.secrets = secrets
+	r.err = err
+}
+
+func (r *switchableRepository) Calls() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
 func TestVault(t *testing.T) {
 	vaultMockData := vault.VaultMockData{
 		"client1": {
@@ -130,4 +185,196 @@ func TestVault(t *testing.T) {
 			Env4: 0,
 		}, secrets)
 	})
+
+	t.Run("Invalidate should reload the client secrets", func(t *testing.T) {
+		repoMock := vault.NewMockVaultRepository(vaultMockData)
+		vaultService := vault.NewVaultService(repoMock)
+
+		vaultService.GetSecret("client1", ENV_1)
+		vaultService.GetSecret("client2", VAR)
+		vaultService.Invalidate("client1")
+		vaultService.GetSecret("client1", ENV_1)
+		vaultService.GetSecret("client2", VAR)
+		assert.Equal(t, 2, repoMock.NumberOfCalls("client1"))
+		assert.Equal(t, 1, repoMock.NumberOfCalls("client2"))
+
+		vaultService.InvalidateAll()
+		vaultService.GetSecret("client1", ENV_1)
+		vaultService.GetSecret("client2", VAR)
+		assert.Equal(t, 3, repoMock.NumberOfCalls("client1"))
+		assert.Equal(t, 2, repoMock.NumberOfCalls("client2"))
+	})
+
+	t.Run("secrets should be reloaded after the cache TTL", func(t *testing.T) {
+		repo := &switchableRepository{secrets: map[string]interface{}{"VAR": "v1"}}
+		vaultService := vault.NewVaultService(repo, vault.WithCacheConfig(vault.VaultCacheConfig{TTL: 20 * time.Millisecond}))
+
+		val, err := vaultService.GetSecret("client1", VAR)
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", val)
+
+		repo.Set(map[string]interface{}{"VAR": "v2"}, nil)
+		assert.Eventually(t, func() bool {
+			val, _ := vaultService.GetSecret("client1", VAR)
+			return val == "v2"
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("stale secrets should be served while Vault is down", func(t *testing.T) {
+		repo := &switchableRepository{secrets: map[string]interface{}{"VAR": "v1"}}
+		vaultService := vault.NewVaultService(repo, vault.WithCacheConfig(vault.VaultCacheConfig{
+			TTL:          20 * time.Millisecond,
+			RefreshAhead: 10 * time.Millisecond,
+			MaxStale:     time.Minute,
+		}))
+
+		vaultService.GetSecret("client1", VAR)
+		repo.Set(nil, errors.New("vault is down"))
+
+		time.Sleep(30 * time.Millisecond)
+		for i := 0; i < 5; i++ {
+			val, err := vaultService.GetSecret("client1", VAR)
+			assert.NoError(t, err)
+			assert.Equal(t, "v1", val)
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Greater(t, repo.Calls(), 1)
+	})
+
+	t.Run("last secrets should be served once expired while Vault is down", func(t *testing.T) {
+		repo := &switchableRepository{secrets: map[string]interface{}{"VAR": "v1"}}
+		vaultService := vault.NewVaultService(repo, vault.WithCacheConfig(vault.VaultCacheConfig{
+			TTL:      20 * time.Millisecond,
+			ErrorTTL: 50 * time.Millisecond,
+		}))
+
+		vaultService.GetSecret("client1", VAR)
+		repo.Set(nil, errors.New("vault is down"))
+
+		time.Sleep(30 * time.Millisecond)
+		for i := 0; i < 5; i++ {
+			val, err := vaultService.GetSecret("client1", VAR)
+			assert.NoError(t, err)
+			assert.Equal(t, "v1", val)
+		}
+		assert.Equal(t, 2, repo.Calls())
+
+		_, err := vaultService.GetSecret("client2", VAR)
+		assert.EqualError(t, err, "vault is down")
+
+		repo.Set(map[string]interface{}{"VAR": "v2"}, nil)
+		assert.Eventually(t, func() bool {
+			val, _ := vaultService.GetSecret("client1", VAR)
+			return val == "v2"
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("hook should be called when secrets change", func(t *testing.T) {
+		type change struct {
+			slug              string
+			previous, current map[string]interface{}
+		}
+		var (
+			mu      sync.Mutex
+			changes []change
+		)
+		repo := &switchableRepository{secrets: map[string]interface{}{"VAR": "v1"}}
+		vaultService := vault.NewVaultService(repo, vault.WithSecretsChangedHook(func(clientSlug string, previous, current map[string]interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			changes = append(changes, change{clientSlug, previous, current})
+		}))
+
+		vaultService.GetSecret("client1", VAR)
+		vaultService.Invalidate("client1")
+		vaultService.GetSecret("client1", VAR)
+
+		repo.Set(map[string]interface{}{"VAR": "v2"}, nil)
+		vaultService.Invalidate("client1")
+		vaultService.GetSecret("client1", VAR)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []change{{
+			slug:     "client1",
+			previous: map[string]interface{}{"VAR": "v1"},
+			current:  map[string]interface{}{"VAR": "v2"},
+		}}, changes)
+	})
+
+	t.Run("WithContext variants should return the context error", func(t *testing.T) {
+		vaultService := vault.NewVaultService(vault.NewMockVaultRepository(vaultMockData))
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := vaultService.GetSecretWithContext(ctx, "client1", ENV_1)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = vaultService.GetSecretsWithContext(ctx, "client1", []vault.VaultSecretKey{ENV_1})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		err = vaultService.ReadSecretsWithContext(ctx, "client1", &SomeSecrets{})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		_, err = vaultService.ListWithContext(ctx)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		val, err := vaultService.GetSecretWithContext(context.Background(), "client1", ENV_1)
+		assert.NoError(t, err)
+		assert.Equal(t, "val 1", val)
+	})
+
+	t.Run("WithContext variants should open a child span tagged with the client slug", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+		defer otel.SetTracerProvider(previous)
+
+		vaultService := vault.NewVaultService(vault.NewMockVaultRepository(vaultMockData))
+		ctx, parent := utils.Tracer.NewSpan(context.Background(), "test", "parent")
+		_, err := vaultService.GetSecretWithContext(ctx, "client1", ENV_1)
+		assert.NoError(t, err)
+		_, err = vaultService.GetSecretWithContext(ctx, "client3", ENV_1)
+		assert.Error(t, err)
+		parent.End()
+
+		spans := recorder.Ended()
+		assert.Len(t, spans, 3)
+		for _, span := range spans[:2] {
+			assert.Equal(t, "Vault Get Secret", span.Name())
+			assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		}
+		assert.Contains(t, spans[0].Attributes(), attribute.String("vault.client_slug", "client1"))
+		assert.Contains(t, spans[1].Attributes(), attribute.String("vault.client_slug", "client3"))
+		assert.Equal(t, codes.Error, spans[1].Status().Code)
+	})
+
+	t.Run("writes should invalidate the client secrets", func(t *testing.T) {
+		ctx := context.Background()
+		repoMock := vault.NewMockVaultRepository(vaultMockData)
+		vaultService := vault.NewVaultService(repoMock)
+
+		val, err := vaultService.GetSecret("client2", VAR)
+		assert.NoError(t, err)
+		assert.Equal(t, "value", val)
+
+		_, err = vaultService.PatchSecrets(ctx, "client2", map[string]interface{}{"VAR": "patched"}, vault.WithCheckAndSet(1))
+		assert.NoError(t, err)
+		val, err = vaultService.GetSecret("client2", VAR)
+		assert.NoError(t, err)
+		assert.Equal(t, "patched", val)
+
+		_, err = vaultService.DeleteSecretKeys(ctx, "client2", []string{string(VAR)})
+		assert.NoError(t, err)
+		val, err = vaultService.GetSecret("client2", VAR)
+		assert.NoError(t, err)
+		assert.Nil(t, val)
+
+		_, err = vaultService.PutSecrets(ctx, "client2", map[string]interface{}{"VAR": "put"}, vault.WithCheckAndSet(1))
+		assert.ErrorIs(t, err, vault.ErrCheckAndSetMismatch)
+
+		assert.NoError(t, vaultService.DeleteClient(ctx, "client2"))
+		_, err = vaultService.GetSecret("client2", VAR)
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+	})
 }
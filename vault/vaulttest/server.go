@@ -0,0 +1,499 @@
+// Package vaulttest provides a fake Vault server for tests that exercise the
+// real Vault client offline. It serves AppRole logins, token renewal and
+// lookup, a KV v2 engine and sys/health over TLS with a self-signed
+// certificate.
+package vaulttest
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+)
+
+const (
+	DefaultRoleId    = "role-id"
+	DefaultSecretId  = "secret-id"
+	DefaultMountPath = "secret"
+	DefaultTokenTTL  = time.Hour
+)
+
+// Request is a request received by the server. Path excludes the /v1 prefix.
+type Request struct {
+	Method string
+	Path   string
+}
+
+// Server is a fake Vault server. Its methods are safe for concurrent use and
+// can change its state while a repository is using it.
+type Server struct {
+	// URL and CertFile are the Url and Cert of the VaultConfig to use it.
+	URL      string
+	CertFile string
+
+	server *httptest.Server
+
+	mu        sync.Mutex
+	roleId    string
+	secretId  string
+	mountPath string
+	tokenTTL  time.Duration
+	tokens    map[string]time.Time
+	secrets   map[string]*secret
+	sealed    bool
+	down      bool
+	requests  []Request
+}
+
+type secret struct {
+	createdTime    time.Time
+	updatedTime    time.Time
+	customMetadata map[string]string
+	versions       []*version
+}
+
+type version struct {
+	data         map[string]interface{}
+	createdTime  time.Time
+	deletionTime time.Time
+	destroyed    bool
+}
+
+type Option func(*Server)
+
+// WithAppRole sets the credentials accepted by the AppRole login, which
+// default to DefaultRoleId and DefaultSecretId.
+func WithAppRole(roleId string, secretId string) Option {
+	return func(s *Server) {
+		s.roleId, s.secretId = roleId, secretId
+	}
+}
+
+// WithMountPath sets the mount path of the KV v2 engine, which defaults to
+// DefaultMountPath.
+func WithMountPath(mountPath string) Option {
+	return func(s *Server) {
+		s.mountPath = mountPath
+	}
+}
+
+// WithTokenTTL sets the TTL of the tokens given by logins and renewals, which
+// defaults to DefaultTokenTTL. Requests with an expired token are denied.
+func WithTokenTTL(ttl time.Duration) Option {
+	return func(s *Server) {
+		s.tokenTTL = ttl
+	}
+}
+
+// WithSecrets stores secrets as version 1 of each client.
+func WithSecrets(secrets vault.VaultMockData) Option {
+	return func(s *Server) {
+		for clientSlug, data := range secrets {
+			s.putSecrets(clientSlug, data)
+		}
+	}
+}
+
+// NewServer starts a server that is closed when the test ends.
+func NewServer(t testing.TB, opts ...Option) *Server {
+	t.Helper()
+
+	s := &Server{
+		roleId:    DefaultRoleId,
+		secretId:  DefaultSecretId,
+		mountPath: DefaultMountPath,
+		tokenTTL:  DefaultTokenTTL,
+		tokens:    map[string]time.Time{},
+		secrets:   map[string]*secret{},
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.server = httptest.NewTLSServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.server.Close)
+	s.URL = s.server.URL
+
+	s.CertFile = filepath.Join(t.TempDir(), "vault.pem")
+	pemData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.server.Certificate().Raw})
+	if err := os.WriteFile(s.CertFile, pemData, 0o600); err != nil {
+		t.Fatalf("unable to write Vault certificate: %v", err)
+	}
+
+	return s
+}
+
+// Config returns a config to connect to the server with AppRole.
+func (s *Server) Config() *vault.VaultConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &vault.VaultConfig{
+		RoleId:    s.roleId,
+		SecretId:  s.secretId,
+		Url:       s.URL,
+		MountPath: s.mountPath,
+		Cert:      s.CertFile,
+	}
+}
+
+// SetSecrets stores secrets as a new version of the client and returns it.
+func (s *Server) SetSecrets(clientSlug string, secrets map[string]interface{}) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.putSecrets(clientSlug, secrets)
+}
+
+// Secrets returns the current secrets of the client.
+func (s *Server) Secrets(clientSlug string) (map[string]interface{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	secret, ok := s.secrets[clientSlug]
+	if !ok {
+		return nil, false
+	}
+	current := secret.versions[len(secret.versions)-1]
+	if current.data == nil {
+		return nil, false
+	}
+	return copyData(current.data), true
+}
+
+// SetSealed makes sys/health report the server as sealed and every other
+// request fail.
+func (s *Server) SetSealed(sealed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sealed = sealed
+}
+
+// SetDown makes every request fail with 503 Service Unavailable.
+func (s *Server) SetDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+// RevokeTokens revokes every token, so requests are denied until the next
+// login.
+func (s *Server) RevokeTokens() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tokens = map[string]time.Time{}
+}
+
+// Requests returns the requests received so far, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+// Count returns how many requests were received for path, with any method.
+func (s *Server) Count(path string) int {
+	count := 0
+	for _, request := range s.Requests() {
+		if request.Path == path {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *Server) Logins() int {
+	return s.Count("auth/approle/login")
+}
+
+func (s *Server) Renewals() int {
+	return s.Count("auth/token/renew-self")
+}
+
+func (s *Server) putSecrets(clientSlug string, data map[string]interface{}) int {
+	now := time.Now().UTC()
+	current, ok := s.secrets[clientSlug]
+	if !ok {
+		current = &secret{createdTime: now, customMetadata: map[string]string{}}
+		s.secrets[clientSlug] = current
+	}
+	current.updatedTime = now
+	current.versions = append(current.versions, &version{data: copyData(data), createdTime: now})
+	return len(current.versions)
+}
+
+func copyData(data map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(data))
+	for key, value := range data {
+		copied[key] = value
+	}
+	return copied
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if body != nil {
+		json.NewEncoder(w).Encode(body)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]interface{}{"errors": []string{message}})
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	method := r.Method
+	if method == http.MethodGet && r.URL.Query().Get("list") == "true" {
+		method = "LIST"
+	}
+
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{Method: method, Path: path})
+
+	if s.down {
+		writeError(w, http.StatusServiceUnavailable, "Vault is unavailable")
+		return
+	}
+	if path == "sys/health" {
+		s.handleHealth(w, r)
+		return
+	}
+	if s.sealed {
+		writeError(w, http.StatusServiceUnavailable, "Vault is sealed")
+		return
+	}
+	if path == "auth/approle/login" {
+		s.handleLogin(w, body)
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if expiresAt, ok := s.tokens[token]; !ok || time.Now().After(expiresAt) {
+		writeError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	switch {
+	case path == "auth/token/renew-self":
+		s.tokens[token] = time.Now().Add(s.tokenTTL)
+		writeJSON(w, http.StatusOK, s.authResponse(token))
+	case path == "auth/token/lookup-self":
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"id":          token,
+			"ttl":         int(time.Until(s.tokens[token]).Seconds()),
+			"renewable":   true,
+			"policies":    []string{"default"},
+			"expire_time": s.tokens[token].UTC().Format(time.RFC3339Nano),
+		}})
+	case strings.HasPrefix(path, s.mountPath+"/data/"):
+		s.handleData(w, r, method, strings.TrimPrefix(path, s.mountPath+"/data/"), body)
+	case strings.HasPrefix(path, s.mountPath+"/metadata/") || path == s.mountPath+"/metadata":
+		s.handleMetadata(w, method, strings.TrimPrefix(strings.TrimPrefix(path, s.mountPath+"/metadata"), "/"))
+	default:
+		writeError(w, http.StatusNotFound, "no handler for route "+path)
+	}
+}
+
+// handleHealth answers with the status codes asked by the client, like Vault.
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusOK
+	if s.sealed {
+		status = http.StatusServiceUnavailable
+		if code, err := strconv.Atoi(r.URL.Query().Get("sealedcode")); err == nil {
+			status = code
+		}
+	}
+	writeJSON(w, status, map[string]interface{}{
+		"initialized":     true,
+		"sealed":          s.sealed,
+		"standby":         false,
+		"server_time_utc": time.Now().Unix(),
+		"version":         "1.15.0",
+		"cluster_name":    "vaulttest",
+	})
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, body map[string]interface{}) {
+	if body["role_id"] != s.roleId || body["secret_id"] != s.secretId {
+		writeError(w, http.StatusBadRequest, "invalid role or secret ID")
+		return
+	}
+
+	token := fmt.Sprintf("hvs.token-%d", len(s.requests))
+	s.tokens[token] = time.Now().Add(s.tokenTTL)
+	writeJSON(w, http.StatusOK, s.authResponse(token))
+}
+
+func (s *Server) authResponse(token string) map[string]interface{} {
+	return map[string]interface{}{"auth": map[string]interface{}{
+		"client_token":   token,
+		"accessor":       "accessor-" + token,
+		"policies":       []string{"default"},
+		"lease_duration": int(s.tokenTTL.Seconds()),
+		"renewable":      true,
+	}}
+}
+
+func versionMetadata(number int, v *version) map[string]interface{} {
+	deletionTime := ""
+	if !v.deletionTime.IsZero() {
+		deletionTime = v.deletionTime.Format(time.RFC3339Nano)
+	}
+	return map[string]interface{}{
+		"version":       number,
+		"created_time":  v.createdTime.Format(time.RFC3339Nano),
+		"deletion_time": deletionTime,
+		"destroyed":     v.destroyed,
+	}
+}
+
+func (s *Server) handleData(w http.ResponseWriter, r *http.Request, method string, clientSlug string, body map[string]interface{}) {
+	current, exists := s.secrets[clientSlug]
+
+	switch method {
+	case http.MethodGet:
+		if !exists {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		number := len(current.versions)
+		if requested := r.URL.Query().Get("version"); requested != "" && requested != "0" {
+			number, _ = strconv.Atoi(requested)
+		}
+		if number < 1 || number > len(current.versions) {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+
+		v := current.versions[number-1]
+		status := http.StatusOK
+		var data interface{}
+		if v.data == nil || !v.deletionTime.IsZero() {
+			status = http.StatusNotFound
+		} else {
+			data = v.data
+		}
+		writeJSON(w, status, map[string]interface{}{"data": map[string]interface{}{
+			"data":     data,
+			"metadata": versionMetadata(number, v),
+		}})
+
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+		currentVersion := 0
+		if exists {
+			currentVersion = len(current.versions)
+		}
+		if options, ok := body["options"].(map[string]interface{}); ok {
+			if cas, ok := options["cas"].(float64); ok && int(cas) != currentVersion {
+				writeError(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+				return
+			}
+		}
+
+		data, _ := body["data"].(map[string]interface{})
+		if method == http.MethodPatch {
+			if !exists || current.versions[currentVersion-1].data == nil {
+				writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+				return
+			}
+			patched := copyData(current.versions[currentVersion-1].data)
+			for key, value := range data {
+				if value == nil {
+					delete(patched, key)
+				} else {
+					patched[key] = value
+				}
+			}
+			data = patched
+		}
+
+		number := s.putSecrets(clientSlug, data)
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"data": versionMetadata(number, s.secrets[clientSlug].versions[number-1]),
+		})
+
+	case http.MethodDelete:
+		if exists {
+			current.versions[len(current.versions)-1].deletionTime = time.Now().UTC()
+		}
+		writeJSON(w, http.StatusNoContent, nil)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
+
+func (s *Server) handleMetadata(w http.ResponseWriter, method string, clientSlug string) {
+	switch method {
+	case "LIST":
+		prefix := clientSlug
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+
+		seen := map[string]bool{}
+		keys := []string{}
+		for path := range s.secrets {
+			if !strings.HasPrefix(path, prefix) {
+				continue
+			}
+			key := strings.TrimPrefix(path, prefix)
+			if i := strings.Index(key, "/"); i >= 0 {
+				key = key[:i+1]
+			}
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		sort.Strings(keys)
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"keys": keys}})
+
+	case http.MethodGet:
+		current, ok := s.secrets[clientSlug]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"errors": []string{}})
+			return
+		}
+		versions := map[string]interface{}{}
+		for i, v := range current.versions {
+			versions[strconv.Itoa(i+1)] = versionMetadata(i+1, v)
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{
+			"created_time":         current.createdTime.Format(time.RFC3339Nano),
+			"updated_time":         current.updatedTime.Format(time.RFC3339Nano),
+			"current_version":      len(current.versions),
+			"oldest_version":       1,
+			"max_versions":         0,
+			"cas_required":         false,
+			"delete_version_after": "0s",
+			"custom_metadata":      current.customMetadata,
+			"versions":             versions,
+		}})
+
+	case http.MethodDelete:
+		delete(s.secrets, clientSlug)
+		writeJSON(w, http.StatusNoContent, nil)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "unsupported operation")
+	}
+}
@@ -0,0 +1,134 @@
+package vaulttest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/criticalmassbr/ms-utils/vault/vaulttest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	newRepository := func(t *testing.T, opts ...vaulttest.Option) (*vaulttest.Server, vault.VaultRepository) {
+		server := vaulttest.NewServer(t, append([]vaulttest.Option{vaulttest.WithSecrets(vault.VaultMockData{
+			"client1":         {"VAR": "value 1"},
+			"group/client2":   {"VAR": "value 2"},
+			"group/a/client3": {"VAR": "value 3"},
+		})}, opts...)...)
+		repo, err := vault.NewVaultRepository(server.Config())
+		assert.NoError(t, err)
+		t.Cleanup(func() { repo.Close() })
+		return server, repo
+	}
+
+	t.Run("NewVaultRepository should log in with AppRole", func(t *testing.T) {
+		server, repo := newRepository(t)
+
+		assert.Equal(t, 1, server.Logins())
+		assert.Equal(t, vault.AuthStateHealthy, repo.AuthStatus().State)
+
+		config := server.Config()
+		config.SecretId = "wrong"
+		_, err := vault.NewVaultRepository(config)
+		assert.ErrorContains(t, err, "invalid role or secret ID")
+	})
+
+	t.Run("secrets should be read through KV v2", func(t *testing.T) {
+		server, repo := newRepository(t)
+
+		secrets, err := repo.GetSecrets("client1")
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"VAR": "value 1"}, secrets)
+
+		server.SetSecrets("client1", map[string]interface{}{"VAR": "value 2"})
+		secrets, err = repo.GetSecretsVersion(ctx, "client1", 1)
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]interface{}{"VAR": "value 1"}, secrets)
+
+		metadata, err := repo.GetMetadata(ctx, "client1")
+		assert.NoError(t, err)
+		assert.Equal(t, 2, metadata.CurrentVersion)
+		assert.Len(t, metadata.Versions, 2)
+
+		_, err = repo.GetSecrets("missing")
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+		_, err = repo.GetMetadata(ctx, "missing")
+		assert.ErrorIs(t, err, vault.ErrSecretNotFound)
+	})
+
+	t.Run("List should return folders and ListRecursive every path", func(t *testing.T) {
+		_, repo := newRepository(t)
+
+		keys, err := repo.List()
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"client1", "group/"}, keys)
+
+		paths, err := repo.ListRecursive(ctx, "group")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, []string{"group/client2", "group/a/client3"}, paths)
+	})
+
+	t.Run("writes should create versions with check-and-set", func(t *testing.T) {
+		server, repo := newRepository(t)
+
+		_, err := repo.PutSecrets(ctx, "client1", map[string]interface{}{"VAR": "put"}, vault.WithCheckAndSet(2))
+		assert.ErrorIs(t, err, vault.ErrCheckAndSetMismatch)
+
+		version, err := repo.PutSecrets(ctx, "client1", map[string]interface{}{"VAR": "put", "OLD": "old"}, vault.WithCheckAndSet(1))
+		assert.NoError(t, err)
+		assert.Equal(t, 2, version)
+
+		version, err = repo.PatchSecrets(ctx, "client1", map[string]interface{}{"VAR": "patched"})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, version)
+
+		version, err = repo.DeleteSecretKeys(ctx, "client1", []string{"OLD"})
+		assert.NoError(t, err)
+		assert.Equal(t, 4, version)
+
+		secrets, _ := server.Secrets("client1")
+		assert.Equal(t, map[string]interface{}{"VAR": "patched"}, secrets)
+
+		assert.NoError(t, repo.DeleteClient(ctx, "client1"))
+		_, ok := server.Secrets("client1")
+		assert.False(t, ok)
+	})
+
+	t.Run("tokens should be renewed before they expire", func(t *testing.T) {
+		server, repo := newRepository(t, vaulttest.WithTokenTTL(2*time.Second))
+		loginExpired := time.Now().Add(2 * time.Second)
+
+		assert.Eventually(t, func() bool {
+			return server.Renewals() > 0 && time.Now().After(loginExpired)
+		}, 3*time.Second, 10*time.Millisecond)
+		_, err := repo.GetSecrets("client1")
+		assert.NoError(t, err)
+		assert.Equal(t, 1, server.Logins())
+	})
+
+	t.Run("revoked tokens should be replaced by a new login", func(t *testing.T) {
+		server, repo := newRepository(t, vaulttest.WithTokenTTL(2*time.Second))
+
+		server.RevokeTokens()
+		assert.Eventually(t, func() bool { return server.Logins() == 2 }, 3*time.Second, 10*time.Millisecond)
+		assert.Eventually(t, func() bool {
+			_, err := repo.GetSecrets("client1")
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("requests should fail while the server is down", func(t *testing.T) {
+		server, repo := newRepository(t)
+
+		server.SetDown(true)
+		_, err := repo.GetSecrets("client1")
+		assert.Error(t, err)
+
+		server.SetDown(false)
+		_, err = repo.GetSecrets("client1")
+		assert.NoError(t, err)
+	})
+}
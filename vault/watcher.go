@@ -0,0 +1,297 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	utils "github.com/criticalmassbr/ms-utils"
+)
+
+const (
+	defaultWatchInterval = 30 * time.Second
+	defaultWatchBuffer   = 16
+)
+
+type SecretsWatcherConfig struct {
+	// Interval between polls, defaults to 30 seconds
+	Interval time.Duration `koanf:"interval"`
+	// ClientSlugs to watch. When empty, every client listed at the root of
+	// the mount is watched, including the ones created later.
+	ClientSlugs []string `koanf:"client_slugs"`
+	// Buffer of the Events channel, defaults to 16
+	Buffer int `koanf:"buffer"`
+}
+
+// SecretsChangeEvent describes a new version of the secrets of a client. Keys
+// are sorted.
+type SecretsChangeEvent struct {
+	ClientSlug string
+	// PreviousVersion is 0 for clients created after the watcher started.
+	PreviousVersion int
+	Version         int
+	Added           []string
+	Removed         []string
+	Changed         []string
+	// Deleted means the client secrets were removed, and all its keys are
+	// listed in Removed.
+	Deleted bool
+}
+
+// SecretsWatcher polls the KV v2 metadata of each client, which is cheaper
+// than reading the secrets, and only reads the secrets of clients with a new
+// version to tell which keys changed.
+type SecretsWatcher struct {
+	service *VaultService
+	config  SecretsWatcherConfig
+
+	mu        sync.Mutex
+	callbacks []func(SecretsChangeEvent)
+	events    chan SecretsChangeEvent
+	closed    bool
+
+	versions map[string]int
+	secrets  map[string]map[string]interface{}
+	// baselined is set once the clients were listed. Clients that could not
+	// be checked then are pending until their first successful check.
+	baselined bool
+	pending   map[string]bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Watch starts polling the secrets of the clients. The first poll that lists
+// the clients records their current versions without emitting events. The cached secrets of a
+// client are invalidated before its change is emitted, so reads from
+// callbacks return the new values.
+func (s *VaultService) Watch(config SecretsWatcherConfig) *SecretsWatcher {
+	w := &SecretsWatcher{
+		service:  s,
+		config:   config,
+		versions: make(map[string]int),
+		secrets:  make(map[string]map[string]interface{}),
+		pending:  make(map[string]bool),
+		done:     make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	go w.run()
+	return w
+}
+
+// OnChange registers a callback called from the polling goroutine for every
+// change, so it should not block.
+func (w *SecretsWatcher) OnChange(callback func(SecretsChangeEvent)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callbacks = append(w.callbacks, callback)
+}
+
+// Events returns a channel receiving every change, closed by Close. Polling
+// waits for the channel to be drained once its buffer is full. After Close it
+// returns a closed channel.
+func (w *SecretsWatcher) Events() <-chan SecretsChangeEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.events == nil {
+		buffer := w.config.Buffer
+		if buffer <= 0 {
+			buffer = defaultWatchBuffer
+		}
+		w.events = make(chan SecretsChangeEvent, buffer)
+		if w.closed {
+			close(w.events)
+		}
+	}
+	return w.events
+}
+
+// Close stops polling and waits for the poll in progress to finish.
+func (w *SecretsWatcher) Close() error {
+	w.cancel()
+	<-w.done
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.events != nil {
+		close(w.events)
+	}
+	return nil
+}
+
+func (w *SecretsWatcher) run() {
+	defer close(w.done)
+
+	interval := w.config.Interval
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+
+	w.poll()
+	for sleep(w.ctx, interval) {
+		w.poll()
+	}
+}
+
+func (w *SecretsWatcher) clientSlugs(ctx context.Context) ([]string, error) {
+	if len(w.config.ClientSlugs) > 0 {
+		return w.config.ClientSlugs, nil
+	}
+
+	keys, err := w.service.repo.ListWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Clients that are no longer listed are still polled to report them as
+	// deleted.
+	known := make(map[string]bool, len(keys)+len(w.versions))
+	clientSlugs := make([]string, 0, len(keys)+len(w.versions))
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/") && !known[key] {
+			known[key] = true
+			clientSlugs = append(clientSlugs, key)
+		}
+	}
+	for clientSlug := range w.versions {
+		if !known[clientSlug] {
+			clientSlugs = append(clientSlugs, clientSlug)
+		}
+	}
+	return clientSlugs, nil
+}
+
+// poll is not interrupted by Close, which waits for it instead, so every poll
+// reads a consistent set of clients.
+func (w *SecretsWatcher) poll() {
+	ctx, span := newSpan(context.Background(), "Vault Watch Secrets", "")
+	defer span.End()
+
+	clientSlugs, err := w.clientSlugs(ctx)
+	if err != nil {
+		utils.Tracer.AddSpanErrorAndFail(span, err, "unable to list clients")
+		return
+	}
+	utils.Tracer.AddSpanTags(span, map[string]string{"vault.watched_clients": strconv.Itoa(len(clientSlugs))})
+
+	baseline := !w.baselined
+	w.baselined = true
+
+	var failed []string
+	for _, clientSlug := range clientSlugs {
+		event, err := w.check(ctx, clientSlug)
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			if baseline {
+				w.pending[clientSlug] = true
+			}
+			failed = append(failed, clientSlug)
+			utils.Tracer.AddSpanEvents(span, "Unable to watch client", map[string]string{"vault.client_slug": clientSlug, "error": err.Error()})
+			continue
+		}
+		pending := baseline || w.pending[clientSlug]
+		delete(w.pending, clientSlug)
+		if event != nil && !pending {
+			utils.Tracer.AddSpanEvents(span, "Secrets changed", map[string]string{"vault.client_slug": clientSlug, "vault.version": strconv.Itoa(event.Version)})
+			w.service.Invalidate(clientSlug)
+			w.emit(*event)
+		}
+	}
+	if len(failed) > 0 {
+		utils.Tracer.AddSpanErrorAndFail(span, errors.New("unable to watch "+strings.Join(failed, ", ")), "unable to watch secrets")
+	}
+}
+
+// check records the current version of the client and returns the change
+// since the last check, if any.
+func (w *SecretsWatcher) check(ctx context.Context, clientSlug string) (*SecretsChangeEvent, error) {
+	previousVersion, known := w.versions[clientSlug]
+
+	metadata, err := w.service.repo.GetMetadata(ctx, clientSlug)
+	if errors.Is(err, ErrSecretNotFound) {
+		if !known {
+			return nil, nil
+		}
+		previous := w.secrets[clientSlug]
+		delete(w.versions, clientSlug)
+		delete(w.secrets, clientSlug)
+		event := diffSecrets(previous, nil)
+		event.ClientSlug, event.PreviousVersion, event.Deleted = clientSlug, previousVersion, true
+		return &event, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if known && metadata.CurrentVersion == previousVersion {
+		return nil, nil
+	}
+
+	// The current version may be deleted or destroyed, which leaves the
+	// client without secrets.
+	secrets, err := w.service.repo.GetSecretsVersion(ctx, clientSlug, metadata.CurrentVersion)
+	if errors.Is(err, ErrSecretVersionDeleted) || errors.Is(err, ErrSecretVersionDestroyed) {
+		secrets, err = map[string]interface{}{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	previous := w.secrets[clientSlug]
+	w.versions[clientSlug] = metadata.CurrentVersion
+	w.secrets[clientSlug] = secrets
+
+	event := diffSecrets(previous, secrets)
+	event.ClientSlug, event.PreviousVersion, event.Version = clientSlug, previousVersion, metadata.CurrentVersion
+	return &event, nil
+}
+
+func (w *SecretsWatcher) emit(event SecretsChangeEvent) {
+	w.mu.Lock()
+	callbacks := append([]func(SecretsChangeEvent){}, w.callbacks...)
+	events := w.events
+	w.mu.Unlock()
+
+	for _, callback := range callbacks {
+		callback(event)
+	}
+	if events != nil {
+		select {
+		case events <- event:
+		case <-w.ctx.Done():
+		}
+	}
+}
+
+func diffSecrets(previous map[string]interface{}, current map[string]interface{}) SecretsChangeEvent {
+	var event SecretsChangeEvent
+	for key, value := range current {
+		previousValue, ok := previous[key]
+		if !ok {
+			event.Added = append(event.Added, key)
+		} else if !reflect.DeepEqual(previousValue, value) {
+			event.Changed = append(event.Changed, key)
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			event.Removed = append(event.Removed, key)
+		}
+	}
+
+	sort.Strings(event.Added)
+	sort.Strings(event.Removed)
+	sort.Strings(event.Changed)
+	return event
+}
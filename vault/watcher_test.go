@@ -0,0 +1,173 @@
+package vault_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/criticalmassbr/ms-utils/vault"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func receiveEvent(t *testing.T, events <-chan vault.SecretsChangeEvent) vault.SecretsChangeEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no change event received")
+		return vault.SecretsChangeEvent{}
+	}
+}
+
+// recordingRepository is a repository recording its calls, like the mock.
+type recordingRepository interface {
+	vault.VaultRepository
+	CallsTo(method string, clientSlug string) []vault.MockCall
+}
+
+// waitForPolls waits until the watcher checked the client polls times. Polls
+// are sequential, so waiting for 2 polls waits for the first one to finish.
+func waitForPolls(t *testing.T, repo recordingRepository, clientSlug string, polls int) {
+	assert.Eventually(t, func() bool {
+		return len(repo.CallsTo("GetMetadata", clientSlug)) >= polls
+	}, time.Second, time.Millisecond)
+}
+
+func TestSecretsWatcher(t *testing.T) {
+	ctx := context.Background()
+	newRepository := func() recordingRepository {
+		return vault.NewMockVaultRepository(vault.VaultMockData{
+			"client1": {"SMTP_HOST": "smtp.client1.com", "SMTP_USER": "user", "SMTP_PASSWORD": "password"},
+			"client2": {"SMS_TOKEN": "token"},
+		})
+	}
+
+	t.Run("should emit the keys added, removed and changed", func(t *testing.T) {
+		repo := newRepository()
+		service := vault.NewVaultService(repo)
+		watcher := service.Watch(vault.SecretsWatcherConfig{Interval: 10 * time.Millisecond})
+		defer watcher.Close()
+		events := watcher.Events()
+
+		waitForPolls(t, repo, "client1", 2)
+		_, err := repo.PutSecrets(ctx, "client1", map[string]interface{}{"SMTP_HOST": "smtp.relay.com", "SMTP_USER": "user", "SMTP_PORT": 587})
+		assert.NoError(t, err)
+
+		event := receiveEvent(t, events)
+		assert.Equal(t, vault.SecretsChangeEvent{
+			ClientSlug:      "client1",
+			PreviousVersion: 1,
+			Version:         2,
+			Added:           []string{"SMTP_PORT"},
+			Removed:         []string{"SMTP_PASSWORD"},
+			Changed:         []string{"SMTP_HOST"},
+		}, event)
+	})
+
+	t.Run("callbacks should read the new secrets", func(t *testing.T) {
+		repo := newRepository()
+		service := vault.NewVaultService(repo)
+		value, _ := service.GetSecretAsString("client2", "SMS_TOKEN")
+		assert.Equal(t, "token", value)
+
+		var mu sync.Mutex
+		var received []string
+		watcher := service.Watch(vault.SecretsWatcherConfig{Interval: 10 * time.Millisecond, ClientSlugs: []string{"client2"}})
+		defer watcher.Close()
+		watcher.OnChange(func(event vault.SecretsChangeEvent) {
+			value, _ := service.GetSecretAsString(event.ClientSlug, "SMS_TOKEN")
+			mu.Lock()
+			defer mu.Unlock()
+			received = append(received, value)
+		})
+
+		waitForPolls(t, repo, "client2", 2)
+		_, err := repo.PatchSecrets(ctx, "client2", map[string]interface{}{"SMS_TOKEN": "rotated"})
+		assert.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received) == 1 && received[0] == "rotated"
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should emit clients created and deleted after starting", func(t *testing.T) {
+		repo := newRepository()
+		service := vault.NewVaultService(repo)
+		watcher := service.Watch(vault.SecretsWatcherConfig{Interval: 10 * time.Millisecond})
+		defer watcher.Close()
+		events := watcher.Events()
+
+		waitForPolls(t, repo, "client1", 2)
+		_, err := repo.PutSecrets(ctx, "client3", map[string]interface{}{"API_KEY": "key"})
+		assert.NoError(t, err)
+		event := receiveEvent(t, events)
+		assert.Equal(t, vault.SecretsChangeEvent{ClientSlug: "client3", Version: 1, Added: []string{"API_KEY"}}, event)
+
+		assert.NoError(t, repo.DeleteClient(ctx, "client2"))
+		event = receiveEvent(t, events)
+		assert.Equal(t, vault.SecretsChangeEvent{ClientSlug: "client2", PreviousVersion: 1, Removed: []string{"SMS_TOKEN"}, Deleted: true}, event)
+	})
+
+	t.Run("every poll should open a span", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		previous := otel.GetTracerProvider()
+		otel.SetTracerProvider(tracesdk.NewTracerProvider(tracesdk.WithSpanProcessor(recorder)))
+		defer otel.SetTracerProvider(previous)
+
+		watcher := vault.NewVaultService(newRepository()).Watch(vault.SecretsWatcherConfig{Interval: 10 * time.Millisecond})
+		assert.Eventually(t, func() bool { return len(recorder.Ended()) >= 2 }, time.Second, time.Millisecond)
+		watcher.Close()
+
+		spans := recorder.Ended()
+		assert.GreaterOrEqual(t, len(spans), 2)
+		for _, span := range spans {
+			assert.Equal(t, "Vault Watch Secrets", span.Name())
+			assert.Contains(t, span.Attributes(), attribute.String("vault.watched_clients", "2"))
+		}
+	})
+
+	t.Run("should not emit existing clients when the first poll fails", func(t *testing.T) {
+		repo := vault.NewMockVaultRepository(vault.VaultMockData{
+			"client1": {"SMTP_HOST": "smtp.client1.com"},
+			"client2": {"SMS_TOKEN": "token"},
+		})
+		repo.SetFault(vault.AnyClient, vault.MockFault{Err: errors.New("vault is down"), Times: 1})
+		repo.SetFault("client2", vault.MockFault{Err: errors.New("vault is down"), Times: 1})
+		watcher := vault.NewVaultService(repo).Watch(vault.SecretsWatcherConfig{Interval: 10 * time.Millisecond})
+		defer watcher.Close()
+		events := watcher.Events()
+
+		waitForPolls(t, repo, "client2", 3)
+		_, err := repo.PatchSecrets(ctx, "client1", map[string]interface{}{"SMTP_HOST": "smtp.relay.com"})
+		assert.NoError(t, err)
+
+		event := receiveEvent(t, events)
+		assert.Equal(t, vault.SecretsChangeEvent{ClientSlug: "client1", PreviousVersion: 1, Version: 2, Changed: []string{"SMTP_HOST"}}, event)
+	})
+
+	t.Run("Events should return a closed channel after Close", func(t *testing.T) {
+		watcher := vault.NewVaultService(newRepository()).Watch(vault.SecretsWatcherConfig{Interval: 10 * time.Millisecond})
+		assert.NoError(t, watcher.Close())
+
+		_, ok := <-watcher.Events()
+		assert.False(t, ok)
+		assert.NoError(t, watcher.Close())
+	})
+
+	t.Run("Close should close the events channel", func(t *testing.T) {
+		watcher := vault.NewVaultService(newRepository()).Watch(vault.SecretsWatcherConfig{Interval: 10 * time.Millisecond})
+		events := watcher.Events()
+		assert.NoError(t, watcher.Close())
+
+		_, ok := <-events
+		assert.False(t, ok)
+	})
+}